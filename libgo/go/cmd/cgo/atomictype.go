@@ -0,0 +1,60 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+)
+
+// atomicWrapper records that typeConv.Type resolved one C11 `_Atomic`
+// type to the sync/atomic-safe integer of the given width (or, failing
+// that, a byte array), for bookkeeping purposes - label is never
+// declared or emitted anywhere; typeConv.Type uses Backing itself as
+// t.Go, not a named wrapper around it.
+//
+// C11 `_Atomic` types decode as DW_TAG_atomic_type, which this
+// toolchain's debug/dwarf returns as an UnsupportedType: unlike
+// DW_TAG_const_type/DW_TAG_volatile_type, it does not carry the
+// wrapped operand type (debug/dwarf's reader never reads the type's
+// AttrType for tags it doesn't otherwise recognize), so the operand's
+// size, signedness and field layout are unrecoverable from here. What
+// can still be done - and is, in typeConv.Type's *dwarf.UnsupportedType
+// case - is avoid the hard fatalf an unhandled DWARF tag would
+// otherwise hit, using the Atomic type's own ByteSize (falling back to
+// the pointer size when DWARF didn't report one, as it commonly
+// doesn't for this tag) to produce a same-sized backing field: one of
+// sync/atomic's safe wrappers when the width is 4 or 8 bytes, or a
+// byte array otherwise.
+type atomicWrapper struct {
+	GoType  string // descriptive label, e.g. "_cgo_atomic_0"; not a declared identifier
+	Backing ast.Expr
+	Bytes   int64
+}
+
+var atomicWrappers []*atomicWrapper
+
+// registerAtomicWrapper records that the C11 _Atomic type labeled
+// goType resolved to an n-byte value, returning the backing field type
+// to use for it: sync/atomic's Uint32 when n is 4, its Uint64 when n
+// is 8, else a plain byte array. The signed Int32/Int64 types are
+// never chosen, since the operand's signedness isn't known here.
+func registerAtomicWrapper(goType string, n int64) ast.Expr {
+	var backing ast.Expr
+	switch n {
+	case 4:
+		backing = ast.NewIdent("atomic.Uint32")
+	case 8:
+		backing = ast.NewIdent("atomic.Uint64")
+	default:
+		backing = &ast.ArrayType{
+			Len: &ast.BasicLit{Kind: token.INT, Value: strconv.FormatInt(n, 10)},
+			Elt: ast.NewIdent("byte"),
+		}
+	}
+	atomicWrappers = append(atomicWrappers, &atomicWrapper{GoType: goType, Backing: backing, Bytes: n})
+	return backing
+}
@@ -0,0 +1,194 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/dwarf"
+	"go/ast"
+)
+
+// targetBigEndian reports whether the target GOARCH stores multi-byte
+// values most-significant-byte first. bitFieldLSBOffset is the only
+// thing that consults it, and only to disambiguate a DWARF corner
+// case (see its comment); everything else defaults to little-endian,
+// which covers the common case (amd64, arm64, riscv64, ...).
+func targetBigEndian() bool {
+	switch goarch {
+	case "ppc64", "mips", "mips64", "s390x", "sparc64":
+		return true
+	}
+	return false
+}
+
+// bitFieldAccessor describes one C bit-field that typeConv.Struct
+// folded into a backing integer field, for the Getter/Setter methods
+// an output stage would generate on the enclosing Go struct type:
+//
+//	func (p *StructGo) FieldC() T {
+//		return T(p.Backing >> Shift & (1<<Bits - 1))
+//	}
+//	func (p *StructGo) SetFieldC(v T) {
+//		const mask = 1<<Bits - 1
+//		p.Backing = p.Backing&^(mask<<Shift) | uint(v)&mask<<Shift
+//	}
+type bitFieldAccessor struct {
+	StructGo string // e.g. "_Ctype_struct_foo"
+	FieldC   string // the bit-field's C name, e.g. "flags"
+	Backing  string // name of the backing field in the Go struct, e.g. "_bitfield0"
+	Bits     int64  // width, in bits
+	Shift    int64  // offset of the field's LSB from the backing field's LSB
+	Unsigned bool   // whether FieldC's declared C type is unsigned
+}
+
+var bitFieldAccessors []*bitFieldAccessor
+
+// registerBitFieldAccessor records that a Getter/Setter pair is needed
+// for one bit-field folded into a backing field. structGo is "" for
+// anonymous structs, which have no stable Go type name to hang
+// methods off of; callers skip registration in that case.
+func registerBitFieldAccessor(structGo, fieldC, backing string, bits, shift int64, unsigned bool) {
+	bitFieldAccessors = append(bitFieldAccessors, &bitFieldAccessor{
+		StructGo: structGo,
+		FieldC:   fieldC,
+		Backing:  backing,
+		Bits:     bits,
+		Shift:    shift,
+		Unsigned: unsigned,
+	})
+}
+
+// bitFieldAccessorsFor returns the accessors registered for structGo,
+// for the output stage to emit, in declaration order.
+func bitFieldAccessorsFor(structGo string) []*bitFieldAccessor {
+	var out []*bitFieldAccessor
+	for _, a := range bitFieldAccessors {
+		if a.StructGo == structGo {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// bitFieldUnit returns the storage unit size, in bytes, that a
+// bit-field needs given the C type it was declared with: the
+// StructField's own ByteSize when DWARF reports one (the usual case
+// for bit-fields), or its type's size otherwise. The result is always
+// one of 1, 2, 4, 8.
+func bitFieldUnit(f *dwarf.StructField) int64 {
+	n := f.ByteSize
+	if n == 0 {
+		n = f.Type.Size()
+	}
+	switch {
+	case n <= 1:
+		return 1
+	case n <= 2:
+		return 2
+	case n <= 4:
+		return 4
+	default:
+		return 8
+	}
+}
+
+// bitFieldBackingType returns the unsigned Go integer type used to
+// back a group of bit-fields occupying n bytes (one of 1, 2, 4, 8).
+func bitFieldBackingType(n int64) ast.Expr {
+	switch n {
+	case 1:
+		return ast.NewIdent("uint8")
+	case 2:
+		return ast.NewIdent("uint16")
+	case 4:
+		return ast.NewIdent("uint32")
+	default:
+		return ast.NewIdent("uint64")
+	}
+}
+
+// bitFieldBackingCType returns the C spelling of the unsigned integer
+// type used to back a group of bit-fields occupying n bytes (one of
+// 1, 2, 4, 8), for the struct's C syntax representation alongside
+// bitFieldBackingType's Go spelling for the same storage unit.
+func bitFieldBackingCType(n int64) string {
+	switch n {
+	case 1:
+		return "unsigned char"
+	case 2:
+		return "unsigned short"
+	case 4:
+		return "unsigned int"
+	default:
+		return "unsigned long long"
+	}
+}
+
+// bitFieldGroup finds the contiguous run of bit-fields in fields
+// starting at i that the compiler packed into the same storage unit
+// (the usual case of several adjacent `f:3` style declarations), and
+// how large a backing integer they need. It grows the backing size as
+// needed, one power of two at a time, and stops the run rather than
+// exceed 8 bytes (the widest backing type Struct supports), so a
+// single oversized bit-field run never blocks unrelated fields.
+func bitFieldGroup(fields []*dwarf.StructField, i int) (run []*dwarf.StructField, backingBytes int64) {
+	base := fields[i].ByteOffset
+	backingBytes = bitFieldUnit(fields[i])
+	j := i
+	for j < len(fields) && fields[j].BitSize > 0 {
+		need := fields[j].ByteOffset - base + bitFieldUnit(fields[j])
+		for need > backingBytes {
+			if backingBytes >= 8 {
+				return fields[i:j], backingBytes
+			}
+			backingBytes *= 2
+		}
+		j++
+	}
+	return fields[i:j], backingBytes
+}
+
+// bitFieldLSBOffset returns the offset, in bits from the
+// least-significant bit of the backingBytes-wide backing word starting
+// at groupOffset, of field f's least-significant bit.
+//
+// DWARF gives two incompatible ways to say where a bit-field sits (see
+// the StructField doc comment in debug/dwarf): legacy BitOffset counts
+// down from the high-order bit of f's own declared type, newer
+// DataBitOffset counts up from bit 0 of the struct. When a producer
+// sets neither (both fields read as their zero value, which is also
+// DataBitOffset's valid "bit 0 of the struct" answer) this falls back
+// to the target's endianness, since that's what actually decided which
+// end of the storage unit the compiler started packing from.
+func bitFieldLSBOffset(f *dwarf.StructField, groupOffset, backingBytes int64) int64 {
+	switch {
+	case f.BitOffset != 0:
+		ownUnitBits := bitFieldUnit(f) * 8
+		return f.ByteOffset*8 + ownUnitBits - f.BitOffset - f.BitSize - groupOffset*8
+	case f.DataBitOffset != 0:
+		return f.DataBitOffset - groupOffset*8
+	case targetBigEndian():
+		return f.ByteOffset*8 + bitFieldUnit(f)*8 - f.BitSize - groupOffset*8
+	default:
+		return f.ByteOffset*8 - groupOffset*8
+	}
+}
+
+// bitFieldUnsigned reports whether dt, a bit-field's declared C type,
+// is unsigned, following typedefs down to the underlying basic type.
+func bitFieldUnsigned(dt dwarf.Type) bool {
+	for {
+		td, ok := dt.(*dwarf.TypedefType)
+		if !ok {
+			break
+		}
+		dt = td.Type
+	}
+	switch dt.(type) {
+	case *dwarf.UintType, *dwarf.UcharType, *dwarf.BoolType:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmd/cgo/internal/constraint"
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// targetFeaturesFlag names the QFTASM target features (e.g.
+// mul,div,mem16,rev2) the driver's target supports, for evaluating a
+// fragment's "//qft:build" constraint.
+var targetFeaturesFlag = flag.String("target-features", "", "comma-separated `list` of QFTASM target features (mul, div, mem16, rev2, ...) available to //qft:build constraints")
+
+// targetFeatureSet parses -target-features into the set a fragment's
+// parsed constraint is Eval'd against.
+func targetFeatureSet() map[string]bool {
+	set := make(map[string]bool)
+	for _, f := range strings.Split(*targetFeaturesFlag, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			set[f] = true
+		}
+	}
+	return set
+}
+
+// fragmentConstraint returns the build constraint governing a QFTASM
+// source fragment or intrinsic stub file, read from the leading
+// "//qft:build <expr>" (or deprecated "// +qft:build <expr>") comment
+// lines at the top of src, and whether any of them used the
+// deprecated legacy form. A fragment with no such line has no
+// constraint (a nil Expr, which includeFragment treats as always
+// satisfied).
+//
+// Like go/build/constraint's handling of the real //go:build and
+// legacy-plus-build forms, only leading blank and comment lines are
+// examined; scanning stops at the first line that is neither, since a
+// constraint line only has meaning as part of the file's header.
+func fragmentConstraint(src []byte) (expr constraint.Expr, legacy bool, err error) {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		if !strings.HasPrefix(trimmed, "//") {
+			break
+		}
+		if !constraint.IsBuildLine(trimmed) && !constraint.IsLegacyBuildLine(trimmed) {
+			continue
+		}
+		x, err := constraint.Parse(trimmed)
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing build constraint %q: %v", trimmed, err)
+		}
+		if constraint.IsLegacyBuildLine(trimmed) {
+			legacy = true
+		}
+		if expr == nil {
+			expr = x
+		} else {
+			expr = &constraint.AndExpr{X: expr, Y: x}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, false, err
+	}
+	return expr, legacy, nil
+}
+
+// includeFragment reports whether the fragment or intrinsic stub file
+// named path, whose contents are src, should be included against the
+// -target-features set. It is the fragment-loading counterpart to
+// fieldPrefix's struct-field filtering: a small, self-contained
+// predicate meant to be
+// called from the fragment loader that assembles a package's QFTASM
+// sources, which does not yet exist in this driver.
+func includeFragment(path string, src []byte) (bool, error) {
+	expr, legacy, err := fragmentConstraint(src)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", path, err)
+	}
+	if legacy {
+		warnf("%s: \"// +qft:build\" is deprecated; use \"//qft:build\" instead", path)
+	}
+	if expr == nil {
+		return true, nil
+	}
+	features := targetFeatureSet()
+	return expr.Eval(func(tag string) bool { return features[tag] }), nil
+}
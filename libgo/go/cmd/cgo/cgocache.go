@@ -0,0 +1,336 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cgoCacheMode controls whether loadDefines, guessKinds, and loadDWARF
+// may skip their gcc invocations by consulting (and populating) an
+// on-disk cache. The default, "rw", both reads and writes the cache;
+// "off" disables it entirely, matching the behavior before the cache
+// was introduced.
+var cgoCacheMode = flag.String("cgo-cache", "rw", "cgo gcc probe cache: off, read, write, or rw")
+
+func cgoCacheRead() bool {
+	return *cgoCacheMode == "read" || *cgoCacheMode == "rw"
+}
+
+func cgoCacheWrite() bool {
+	return *cgoCacheMode == "write" || *cgoCacheMode == "rw"
+}
+
+// cgoCacheVersion must be bumped whenever cgoCacheEntry's shape, or the
+// meaning of any of its fields, changes, so that entries written by an
+// older cgo are ignored instead of misread.
+const cgoCacheVersion = 1
+
+// cgoCacheEntry is the serialized result of the three gcc-driven probes
+// that loadDefines, guessKinds, and loadDWARF perform for a given set
+// of C.xxx names: the #defines gcc reported, the resolved kind/const
+// for each name, and enough of the DWARF-derived debug data for
+// loadDWARF to populate f.Name without rerunning gcc.
+type cgoCacheEntry struct {
+	Version int
+
+	Defines map[string]string // key -> #define value, as loadDefines would set n.Define
+	IsClang bool
+
+	Kinds map[string]cgoCacheKind // Go name -> resolved kind/const
+
+	// DWARFNames is the needType slice, in the exact order it was
+	// passed to loadDWARF, so that the __cgo__i variables found in
+	// DWARFObj can be matched back up to names on a cache hit.
+	// Left empty when DWARFConcurrent is true.
+	DWARFNames []string
+	DWARFObj   []byte // the gcc -gdwarf-2 object file, for gccDebug to reparse
+
+	// DWARFConcurrent records that needType was resolved through
+	// loadDWARFConcurrently (gccparallel.go) rather than the single
+	// gcc invocation that writes gccTmp(): loadDWARFConcurrently
+	// probes each batch at its own "<gccTmp>.probeN" path and removes
+	// it once applied, so there is no single combined object here for
+	// saveToCache to capture. Those probes are cached individually,
+	// at the finer-grained gccProbeCache, instead; loadFromCache
+	// treats a DWARFConcurrent entry as a miss and falls back to
+	// rerunning loadDWARF, which will hit that cache per batch.
+	DWARFConcurrent bool
+}
+
+type cgoCacheKind struct {
+	Kind  string
+	Const string
+}
+
+// cgoCacheDir returns the directory holding cached probe results,
+// defaulting to a "cgo" subdirectory of $GOCACHE.
+func cgoCacheDir() string {
+	if dir := os.Getenv("GOCACHE"); dir != "" {
+		return filepath.Join(dir, "cgo")
+	}
+	return filepath.Join(os.TempDir(), "go-cgo-cache")
+}
+
+// cgoCacheKeyFor hashes everything that can change the outcome of the
+// gcc probes for f: its preamble, the package's gcc options, the
+// target (goarch/goos, which select gccMachine's -m flags), the
+// sorted set of C.xxx names referenced, the gcc version string, and
+// the contents of every non-system header the preamble pulls in via
+// #include, so that editing such a header invalidates the cache entry
+// rather than returning a stale, silently out-of-date one. Headers
+// found in system header directories are not hashed.
+func cgoCacheKeyFor(p *Package, f *File) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "cgo-cache-v%d\n", cgoCacheVersion)
+	io.WriteString(h, f.Preamble)
+	io.WriteString(h, "\x00")
+
+	fmt.Fprintf(h, "%s/%s\x00", goarch, goos)
+
+	opts := append([]string(nil), p.GccOptions...)
+	sort.Strings(opts)
+	for _, o := range opts {
+		io.WriteString(h, o)
+		io.WriteString(h, "\x00")
+	}
+
+	names := nameKeys(f.Name)
+	sort.Strings(names)
+	for _, n := range names {
+		io.WriteString(h, n)
+		io.WriteString(h, "\x00")
+	}
+
+	io.WriteString(h, gccVersionString(p))
+	io.WriteString(h, "\x00")
+
+	for _, inc := range p.gccIncludeFiles(f) {
+		io.WriteString(h, inc.path)
+		io.WriteString(h, "\x00")
+		io.WriteString(h, inc.hash)
+		io.WriteString(h, "\x00")
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// gccVersionString returns "gcc -v"'s stderr output, which is good
+// enough to invalidate the cache whenever the compiler is upgraded.
+func gccVersionString(p *Package) string {
+	_, stderr := runGcc(nil, append(p.gccBaseCmd(), "-v"))
+	return stderr
+}
+
+// includeFile is one header file gccIncludeFiles found the preamble
+// depending on, along with a content hash to detect edits to it.
+type includeFile struct {
+	path string
+	hash string
+}
+
+// gccIncludeFiles asks gcc (via "-M -MM", which lists included
+// headers but omits those found in system header directories) which files f's preamble
+// pulls in via #include, then hashes each one's contents. A header
+// gcc can't find, or that's since been removed, is silently skipped:
+// whatever probe runs next will hit the same gcc error and report it
+// properly, so there's no need to duplicate that failure here.
+func (p *Package) gccIncludeFiles(f *File) []includeFile {
+	var b bytes.Buffer
+	b.WriteString(f.Preamble)
+	b.WriteString(builtinProlog)
+
+	base := append(p.gccBaseCmd(), "-M", "-MM", "-xc")
+	base = append(base, p.gccMachine()...)
+	stdout, _ := runGcc(b.Bytes(), append(append(base, p.GccOptions...), "-"))
+
+	paths := parseMakeDepends(stdout)
+	sort.Strings(paths)
+
+	includes := make([]includeFile, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256(data)
+		includes = append(includes, includeFile{path: path, hash: hex.EncodeToString(sum[:])})
+	}
+	return includes
+}
+
+// parseMakeDepends extracts the dependency paths out of the
+// Makefile-rule output gcc's "-M -MM" flags produce: a "target:
+// dep dep ... \\\ndep dep ..." rule, continuation lines joined with a
+// trailing backslash. The target itself (stdin's made-up object name)
+// is skipped.
+func parseMakeDepends(rule string) []string {
+	rule = strings.ReplaceAll(rule, "\\\n", " ")
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return nil
+	}
+	var paths []string
+	for _, field := range fields[1:] {
+		if field == ":" {
+			continue
+		}
+		paths = append(paths, field)
+	}
+	return paths
+}
+
+func cgoCacheLoad(key string) (*cgoCacheEntry, bool) {
+	if !cgoCacheRead() {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(cgoCacheDir(), key))
+	if err != nil {
+		return nil, false
+	}
+	var e cgoCacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return nil, false
+	}
+	if e.Version != cgoCacheVersion {
+		return nil, false
+	}
+	return &e, true
+}
+
+// loadFromCache tries to populate f.Name entirely from a cached probe
+// result, returning true on success. On a miss it returns false and
+// leaves f.Name untouched, so the caller should fall back to running
+// loadDefines/guessKinds/loadDWARF as usual.
+func (p *Package) loadFromCache(f *File) bool {
+	if !cgoCacheRead() {
+		return false
+	}
+	key := cgoCacheKeyFor(p, f)
+	e, ok := cgoCacheLoad(key)
+	if !ok {
+		return false
+	}
+	if e.DWARFConcurrent {
+		return false
+	}
+
+	// Every check that can turn this into a miss runs before f.Name
+	// is changed.
+	for _, name := range nameKeys(f.Name) {
+		if _, ok := e.Kinds[name]; !ok {
+			// The cached probe didn't classify every current
+			// name (e.g. the preamble changed which names are
+			// referenced); treat this as a miss rather than risk
+			// silently leaving a name unresolved.
+			return false
+		}
+	}
+	names := make([]*Name, len(e.DWARFNames))
+	for i, goName := range e.DWARFNames {
+		n, ok := f.Name[goName]
+		if !ok {
+			return false
+		}
+		names[i] = n
+	}
+	if len(names) > 0 {
+		if err := ioutil.WriteFile(gccTmp(), e.DWARFObj, 0644); err != nil {
+			return false
+		}
+	}
+
+	p.GccIsClang = e.IsClang
+	for _, name := range nameKeys(f.Name) {
+		n := f.Name[name]
+		if def, ok := e.Defines[name]; ok {
+			n.Define = def
+		}
+		k := e.Kinds[name]
+		n.Kind = k.Kind
+		n.Const = k.Const
+	}
+
+	if len(names) == 0 {
+		// No names needed DWARF information; nothing left to do.
+		return true
+	}
+	d, ints, floats, strs := p.parseGccObject(len(names))
+	p.populateFromDWARF(f, names, d, ints, floats, strs)
+	return true
+}
+
+// saveToCache writes the results of loadDefines/guessKinds/loadDWARF
+// for f to the on-disk cache, so a future invocation with the same
+// preamble, flags, and referenced names can skip gcc entirely.
+// needType is the same slice loadDWARF was called with, if any, and
+// dwarfConcurrent is loadDWARF's own return value, reporting whether
+// it resolved needType through loadDWARFConcurrently instead of the
+// single gcc invocation that writes gccTmp().
+func (p *Package) saveToCache(f *File, needType []*Name, dwarfConcurrent bool) {
+	if !cgoCacheWrite() {
+		return
+	}
+	e := &cgoCacheEntry{
+		IsClang: p.GccIsClang,
+		Defines: make(map[string]string),
+		Kinds:   make(map[string]cgoCacheKind),
+	}
+	for _, name := range nameKeys(f.Name) {
+		n := f.Name[name]
+		if n.Define != "" {
+			e.Defines[name] = n.Define
+		}
+		if n.Kind != "" {
+			e.Kinds[name] = cgoCacheKind{Kind: n.Kind, Const: n.Const}
+		}
+	}
+	if len(needType) > 0 {
+		if dwarfConcurrent {
+			// No single object file captures this probe; see
+			// DWARFConcurrent's doc comment.
+			e.DWARFConcurrent = true
+		} else {
+			for _, n := range needType {
+				e.DWARFNames = append(e.DWARFNames, n.Go)
+			}
+			if data, err := ioutil.ReadFile(gccTmp()); err == nil {
+				e.DWARFObj = data
+			}
+		}
+	}
+	cgoCacheStore(cgoCacheKeyFor(p, f), e)
+}
+
+func cgoCacheStore(key string, e *cgoCacheEntry) {
+	if !cgoCacheWrite() {
+		return
+	}
+	e.Version = cgoCacheVersion
+	dir := cgoCacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return
+	}
+	tmp := filepath.Join(dir, key+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, filepath.Join(dir, key))
+}
@@ -0,0 +1,91 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+)
+
+// useClangAST forces the clang-ast-dump probe in guessKinds even when
+// p.GccIsClang wasn't already detected from the #define probe (useful
+// when the compiler is clang but loadDefines hasn't run yet, or when
+// testing the path against a system that reports itself otherwise).
+var useClangAST = flag.Bool("use-clang-ast", false, "classify C.xxx names via clang -ast-dump=json instead of error-message probing")
+
+// clangASTNode is the subset of clang's -ast-dump=json schema that
+// guessKindsFromAST cares about: enough to find top-level declarations
+// by name and, for enum constants, their value.
+type clangASTNode struct {
+	Kind  string          `json:"kind"`
+	Name  string          `json:"name"`
+	Inner []clangASTNode  `json:"inner"`
+	Value json.RawMessage `json:"value"`
+}
+
+// guessKindsFromAST classifies each of names using clang's structured
+// AST dump instead of the not-declared/not-type error-message probe
+// guessKinds otherwise relies on. It returns false (changing nothing)
+// if clang's output can't be parsed, so the caller can fall back to
+// the gcc-error-message path and keep working with gcc-only
+// toolchains.
+func (p *Package) guessKindsFromAST(f *File, names []*Name) bool {
+	var b bytes.Buffer
+	b.WriteString(f.Preamble)
+	b.WriteString(builtinProlog)
+	for _, n := range names {
+		fmt.Fprintf(&b, "__typeof__(%s) *__cgo_ast_%s__;\n", n.C, n.Go)
+	}
+
+	args := append(p.gccBaseCmd(), "-Xclang", "-ast-dump=json", "-fsyntax-only", "-xc")
+	args = append(args, p.GccOptions...)
+	args = append(args, p.gccMachine()...)
+	args = append(args, "-")
+	stdout, _ := runGcc(b.Bytes(), args)
+
+	var root clangASTNode
+	if err := json.Unmarshal([]byte(stdout), &root); err != nil {
+		return false
+	}
+
+	decls := make(map[string]clangASTNode)
+	var walk func(clangASTNode)
+	walk = func(node clangASTNode) {
+		if node.Name != "" {
+			decls[node.Name] = node
+		}
+		for _, child := range node.Inner {
+			walk(child)
+		}
+	}
+	walk(root)
+
+	ok := true
+	for _, n := range names {
+		d, found := decls[n.Go]
+		if !found {
+			ok = false
+			continue
+		}
+		switch d.Kind {
+		case "FunctionDecl":
+			n.Kind = "func"
+		case "TypedefDecl", "RecordDecl", "EnumDecl":
+			n.Kind = "type"
+		case "EnumConstantDecl":
+			n.Kind = "iconst"
+			if len(d.Value) > 0 {
+				n.Const = string(d.Value)
+			}
+		case "VarDecl":
+			n.Kind = "var"
+		default:
+			ok = false
+		}
+	}
+	return ok
+}
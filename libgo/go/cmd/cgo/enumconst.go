@@ -0,0 +1,75 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/dwarf"
+	"go/ast"
+)
+
+// enumConstant describes one Go `const` declaration an output stage
+// would emit for a C enumerator, using the same "_C" + kind + "_" +
+// name mangling cgo already applies to C.FOO references resolved as
+// "iconst" (see Package.mangleName): const _Ciconst_RED = 0. Unlike
+// those, an enumerator's value comes straight from the EnumType's Val
+// list that typeConv.Type already collects into EnumValues, with no
+// need to probe gcc for it.
+type enumConstant struct {
+	Go     string // C enumerator name, e.g. "RED"
+	Mangle string // _Ciconst_RED
+	Value  int64
+	GoType ast.Expr // the enum's chosen Go integer type
+}
+
+// enumStringer describes a String() method an output stage could
+// generate for a named C enum type, mapping each of its values back
+// to the enumerator name it came from.
+type enumStringer struct {
+	GoType string           // e.g. "_Ctype_enum_color"
+	Names  map[int64]string // value -> enumerator name
+}
+
+var (
+	enumConstants    []*enumConstant
+	enumConstantSeen = make(map[string]bool)
+
+	enumStringers    []*enumStringer
+	enumStringerSeen = make(map[string]bool)
+)
+
+// registerEnumConstants records a Go const declaration for every
+// enumerator of dt, typed as t.Go, and - if dt is a tagged enum, so it
+// has a stable Go type name to hang a method off of - a String()
+// method mapping values back to names. It's idempotent: typeConv.Type
+// may see the same enum type more than once (e.g. once per
+// reference), and only the first registers anything.
+func registerEnumConstants(dt *dwarf.EnumType, t *Type) {
+	for _, ev := range dt.Val {
+		if enumConstantSeen[ev.Name] {
+			continue
+		}
+		enumConstantSeen[ev.Name] = true
+		enumConstants = append(enumConstants, &enumConstant{
+			Go:     ev.Name,
+			Mangle: "_Ciconst_" + ev.Name,
+			Value:  ev.Val,
+			GoType: t.Go,
+		})
+	}
+
+	if dt.EnumName == "" {
+		return
+	}
+	goType := "_Ctype_enum_" + dt.EnumName
+	if enumStringerSeen[goType] {
+		return
+	}
+	enumStringerSeen[goType] = true
+	names := make(map[int64]string, len(dt.Val))
+	for _, ev := range dt.Val {
+		names[ev.Val] = ev.Name
+	}
+	enumStringers = append(enumStringers, &enumStringer{GoType: goType, Names: names})
+}
@@ -0,0 +1,75 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"sync"
+)
+
+// funcPointersFlag enables collecting the Go func(...) signatures
+// (via typeConv.FuncType) that typedef'd C function pointer types
+// would need trampolines for, so that the output-writing stage that
+// emits those trampolines and switches the generated type from
+// uintptr to the real func type can be built against this data. Until
+// that stage exists, typeConv.Type keeps emitting the default,
+// conservative uintptr regardless of this flag: exposing the real Go
+// func type without a generated trampoline backing it would let
+// callers invoke it directly, a direct call across the Go/C boundary
+// that the uintptr encoding exists specifically to prevent.
+var funcPointersFlag = flag.Bool("cgo-func-pointers", false, "collect typedef'd C function pointer signatures that would need trampolines, in preparation for Go func type translation")
+
+// funcPointerTrampoline describes the generated glue needed for one
+// distinct C function pointer signature: a C-callable stub that
+// invokes a Go func value (so the Go value can be handed to C as a
+// callback), and a Go wrapper that invokes a C function pointer value
+// (so a callback C hands back to Go can be called directly). The
+// actual stub/wrapper source is emitted by the output-writing stage
+// (out.go's real counterpart, cgo's _cgo_export.c/_cgo_gotypes.go
+// generation) from this description; registerFuncPointerType only
+// collects which signatures are needed and de-duplicates them.
+type funcPointerTrampoline struct {
+	Name string // mangled name, e.g. _cgo_funcptr_0
+	Go   *ast.FuncType
+}
+
+var (
+	funcPointerMu    sync.Mutex
+	funcPointerSeen  = make(map[string]*funcPointerTrampoline)
+	funcPointerOrder []*funcPointerTrampoline
+)
+
+// registerFuncPointerType records that ft's signature needs a
+// trampoline, returning the (possibly previously registered)
+// trampoline describing it. Signatures are de-duplicated by their Go
+// spelling, so `void (*)(int)` only generates one trampoline no
+// matter how many typedefs alias it.
+func registerFuncPointerType(ft *FuncType) *funcPointerTrampoline {
+	key := gofmt(ft.Go)
+
+	funcPointerMu.Lock()
+	defer funcPointerMu.Unlock()
+
+	if tr, ok := funcPointerSeen[key]; ok {
+		return tr
+	}
+	tr := &funcPointerTrampoline{
+		Name: fmt.Sprintf("_cgo_funcptr_%d", len(funcPointerOrder)),
+		Go:   ft.Go,
+	}
+	funcPointerSeen[key] = tr
+	funcPointerOrder = append(funcPointerOrder, tr)
+	return tr
+}
+
+// funcPointerTrampolines returns every trampoline registered so far,
+// in the order they were first seen, for the output stage to emit.
+func funcPointerTrampolines() []*funcPointerTrampoline {
+	funcPointerMu.Lock()
+	defer funcPointerMu.Unlock()
+	return append([]*funcPointerTrampoline(nil), funcPointerOrder...)
+}
@@ -9,6 +9,8 @@ package main
 
 import (
 	"bytes"
+	"cmd/cgo/internal/mangle"
+	"cmd/cgo/internal/symtab"
 	"debug/dwarf"
 	"debug/elf"
 	"debug/macho"
@@ -20,8 +22,10 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"internal/xcoff"
 	"math"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -31,6 +35,15 @@ import (
 var debugDefine = flag.Bool("debug-define", false, "print relevant #defines")
 var debugGcc = flag.Bool("debug-gcc", false, "print gcc invocations")
 
+// incomplete is the Go type typeConv.Type substitutes for a C struct,
+// union, or class whose DWARF size is unknown, as for a type that is
+// only forward-declared (as public APIs commonly do for handle-like
+// types). Enums are not covered, and rewriteRef does not use it: a
+// reference it has no DWARF information for is still an error. Go
+// code may still form and pass around pointers to it; it may not be
+// dereferenced or allocated.
+var incomplete = "_cgopackage.Incomplete"
+
 var nameToC = map[string]string{
 	"schar":         "signed char",
 	"uchar":         "unsigned char",
@@ -71,6 +84,7 @@ func cname(s string) string {
 // all #cgo CFLAGS and LDFLAGS directives, so they don't make their
 // way into _cgo_export.h.
 func (f *File) DiscardCgoDirectives() {
+	initTypeMap()
 	linesIn := strings.Split(f.Preamble, "\n")
 	linesOut := make([]string, 0, len(linesIn))
 	for _, line := range linesIn {
@@ -78,6 +92,9 @@ func (f *File) DiscardCgoDirectives() {
 		if len(l) < 5 || l[:4] != "#cgo" || !unicode.IsSpace(rune(l[4])) {
 			linesOut = append(linesOut, line)
 		} else {
+			if rest := strings.TrimSpace(l[5:]); strings.HasPrefix(rest, "typemap:") {
+				loadTypeMapFile(strings.TrimSpace(rest[len("typemap:"):]))
+			}
 			linesOut = append(linesOut, "")
 		}
 	}
@@ -181,10 +198,14 @@ func (p *Package) Translate(f *File) {
 		// Convert C.ulong to C.unsigned long, etc.
 		cref.Name.C = cname(cref.Name.Go)
 	}
-	p.loadDefines(f)
-	needType := p.guessKinds(f)
-	if len(needType) > 0 {
-		p.loadDWARF(f, needType)
+	if !p.loadFromCache(f) {
+		p.loadDefines(f)
+		needType := p.guessKinds(f)
+		dwarfConcurrent := false
+		if len(needType) > 0 {
+			dwarfConcurrent = p.loadDWARF(f, needType)
+		}
+		p.saveToCache(f, needType, dwarfConcurrent)
 	}
 	if p.rewriteCalls(f) {
 		// Add `import _cgo_unsafe "unsafe"` as the first decl
@@ -206,6 +227,108 @@ func (p *Package) Translate(f *File) {
 	p.rewriteRef(f)
 }
 
+// TranslateAll rewrites every file in files the same way Translate
+// does, but first resolves all the C.xxx names across every file with
+// one probe each (defines, kinds, DWARF) for the whole set of files
+// instead of one per file; the DWARF probe may still be split into
+// several gcc invocations by loadDWARFConcurrently. This matters for packages with many cgo files that
+// share an essentially identical preamble, where gcc's startup and
+// header-parsing cost would otherwise be paid once per file.
+func (p *Package) TranslateAll(files []*File) {
+	if len(files) <= 1 {
+		for _, f := range files {
+			p.Translate(f)
+		}
+		return
+	}
+
+	for _, f := range files {
+		for _, cref := range f.Ref {
+			cref.Name.C = cname(cref.Name.Go)
+		}
+	}
+
+	combined, _ := p.combineFiles(files)
+	if !p.loadFromCache(combined) {
+		p.loadDefines(combined)
+		needType := p.guessKinds(combined)
+		dwarfConcurrent := false
+		if len(needType) > 0 {
+			dwarfConcurrent = p.loadDWARF(combined, needType)
+		}
+		p.saveToCache(combined, needType, dwarfConcurrent)
+	}
+
+	// Distribute the resolved Name entries back into each file that
+	// referenced them.
+	for key, n := range combined.Name {
+		for _, f := range files {
+			if fn, ok := f.Name[key]; ok {
+				*fn = *n
+			}
+		}
+	}
+
+	for _, f := range files {
+		if p.rewriteCalls(f) {
+			imp := &ast.GenDecl{
+				Tok: token.IMPORT,
+				Specs: []ast.Spec{
+					&ast.ImportSpec{
+						Name: ast.NewIdent("_cgo_unsafe"),
+						Path: &ast.BasicLit{
+							Kind:  token.STRING,
+							Value: `"unsafe"`,
+						},
+					},
+				},
+			}
+			f.AST.Decls = append([]ast.Decl{imp}, f.AST.Decls...)
+		}
+		p.rewriteRef(f)
+	}
+}
+
+// combineFiles builds a synthetic *File whose preamble is the
+// concatenation of every file's preamble (safe because well-formed C
+// headers guard themselves against multiple inclusion) and whose Name
+// map is the union, by Go name, of every file's Name map. It also
+// returns, for every name, the first file that referenced it, for use
+// in diagnostics.
+func (p *Package) combineFiles(files []*File) (combined *File, origin map[string]*File) {
+	combined = &File{Name: make(map[string]*Name)}
+	origin = make(map[string]*File)
+	var preambles []string
+	for _, f := range files {
+		preambles = append(preambles, f.Preamble)
+		for key, n := range f.Name {
+			if _, ok := combined.Name[key]; ok {
+				continue
+			}
+			cp := new(Name)
+			*cp = *n
+			combined.Name[key] = cp
+			origin[key] = f
+
+			// Carry over one originating Ref so that error_ calls
+			// made while resolving the combined file still point at
+			// real source, rather than at token.NoPos.
+			for _, ref := range f.Ref {
+				if ref.Name == n {
+					combined.Ref = append(combined.Ref, &Ref{
+						Name:    cp,
+						Expr:    ref.Expr,
+						Context: ref.Context,
+					})
+					break
+				}
+			}
+		}
+	}
+	combined.Preamble = strings.Join(preambles, "\n")
+	return combined, origin
+}
+
 // loadDefines coerces gcc into spitting out the #defines in use
 // in the file f and saves relevant renamings in f.Name[name].Define.
 func (p *Package) loadDefines(f *File) {
@@ -304,6 +427,18 @@ func (p *Package) guessKinds(f *File) []*Name {
 		return needType
 	}
 
+	// When the compiler is clang, prefer asking it directly via a
+	// structured AST dump over grepping synthetic error messages,
+	// which is brittle across gcc/clang versions. Fall back to the
+	// error-message probe below if the AST dump can't be parsed (for
+	// example an old clang without -ast-dump=json, or a gcc-only
+	// toolchain that merely claims to be clang-compatible), so builds
+	// still work everywhere the error-message probe did.
+	if (p.GccIsClang || *useClangAST) && p.guessKindsFromAST(f, names) {
+		needType = append(needType, names...)
+		return needType
+	}
+
 	// Coerce gcc into telling us whether each name is a type, a value, or undeclared.
 	// For names, find out whether they are integer constants.
 	// We used to look at specific warning or error messages here, but that tied the
@@ -498,8 +633,10 @@ func (p *Package) guessKinds(f *File) []*Name {
 
 // loadDWARF parses the DWARF debug information generated
 // by gcc to learn the details of the constants, variables, and types
-// being referred to as C.xxx.
-func (p *Package) loadDWARF(f *File, names []*Name) {
+// being referred to as C.xxx. It reports whether the probe ran through
+// loadDWARFConcurrently, so saveToCache knows not to trust the single
+// shared gccTmp() object as this probe's result.
+func (p *Package) loadDWARF(f *File, names []*Name) bool {
 	// Extract the types from the DWARF section of an object
 	// from a well-formed C program. Gcc only generates DWARF info
 	// for symbols in the object file, so it is not enough to print the
@@ -508,57 +645,26 @@ func (p *Package) loadDWARF(f *File, names []*Name) {
 	//	__typeof__(names[i]) *__cgo__i;
 	// for each entry in names and then dereference the type we
 	// learn for __cgo__i.
-	var b bytes.Buffer
-	b.WriteString(f.Preamble)
-	b.WriteString(builtinProlog)
-	b.WriteString("#line 1 \"cgo-dwarf-inference\"\n")
-	for i, n := range names {
-		fmt.Fprintf(&b, "__typeof__(%s) *__cgo__%d;\n", n.C, i)
-		if n.Kind == "iconst" || n.Kind == "uconst" {
-			fmt.Fprintf(&b, "enum { __cgo_enum__%d = %s };\n", i, n.C)
-		}
-	}
-
-	// We create a data block initialized with the values,
-	// so we can read them out of the object file.
-	fmt.Fprintf(&b, "long long __cgodebug_ints[] = {\n")
-	for _, n := range names {
-		if n.Kind == "iconst" || n.Kind == "uconst" {
-			fmt.Fprintf(&b, "\t%s,\n", n.C)
-		} else {
-			fmt.Fprintf(&b, "\t0,\n")
-		}
-	}
-	// for the last entry, we cannot use 0, otherwise
-	// in case all __cgodebug_data is zero initialized,
-	// LLVM-based gcc will place the it in the __DATA.__common
-	// zero-filled section (our debug/macho doesn't support
-	// this)
-	fmt.Fprintf(&b, "\t1\n")
-	fmt.Fprintf(&b, "};\n")
-
-	// do the same work for floats.
-	fmt.Fprintf(&b, "double __cgodebug_floats[] = {\n")
-	for _, n := range names {
-		if n.Kind == "fconst" {
-			fmt.Fprintf(&b, "\t%s,\n", n.C)
-		} else {
-			fmt.Fprintf(&b, "\t0,\n")
-		}
-	}
-	fmt.Fprintf(&b, "\t1\n")
-	fmt.Fprintf(&b, "};\n")
-
-	// do the same work for strings.
-	for i, n := range names {
-		if n.Kind == "sconst" {
-			fmt.Fprintf(&b, "const char __cgodebug_str__%d[] = %s;\n", i, n.C)
-			fmt.Fprintf(&b, "const unsigned long long __cgodebug_strlen__%d = sizeof(%s)-1;\n", i, n.C)
-		}
+	//
+	// When there are enough names to be worth it, this probe is
+	// split into batches and run across a bounded worker pool by
+	// loadDWARFConcurrently (gccparallel.go) instead of issuing one
+	// gcc invocation for all of names.
+	if p.loadDWARFConcurrently(f, names) {
+		return true
 	}
 
-	d, ints, floats, strs := p.gccDebug(b.Bytes(), len(names))
+	b := p.dwarfProbeSource(f, names)
+	d, ints, floats, strs := p.gccDebug(b, len(names))
+	p.populateFromDWARF(f, names, d, ints, floats, strs)
+	return false
+}
 
+// populateFromDWARF records, into each of names, the type (and any
+// constant value) that d/ints/floats/strs say it has. It is the tail
+// half of loadDWARF, split out so that cgocache.go can reuse it for
+// names resolved from a cached object file instead of a fresh gcc run.
+func (p *Package) populateFromDWARF(f *File, names []*Name, d *dwarf.Data, ints []int64, floats []float64, strs []string) {
 	// Scan DWARF info for top-level TagVariable entries with AttrName __cgo__i.
 	types := make([]dwarf.Type, len(names))
 	nameToIndex := make(map[*Name]int)
@@ -664,6 +770,7 @@ func (p *Package) mangleName(n *Name) {
 		prefix = "C"
 	}
 	n.Mangle = prefix + n.Kind + "_" + n.Go
+	recordMangledSymbol(n.Mangle, n.Go)
 }
 
 // rewriteCalls rewrites all calls that pass pointers to check that
@@ -693,6 +800,11 @@ func (p *Package) rewriteCalls(f *File) bool {
 // If any pointer checks are required, we rewrite the call into a
 // function literal that calls _cgoCheckPointer for each pointer
 // argument and then calls the original function.
+// If the call is a two-result "call2" reference, the literal also
+// resets errno before the call and turns its value into the second
+// (error) result, via the _cgo_errno helpers and errnoToError that
+// out.go's errnoProlog documents (and an output-writing stage would
+// emit into the generated package, the same as _Cgo_ptr below).
 // This returns whether the package needs to import unsafe as _cgo_unsafe.
 func (p *Package) rewriteCall(f *File, call *Call, name *Name) bool {
 	// Avoid a crash if the number of arguments is
@@ -709,7 +821,18 @@ func (p *Package) rewriteCall(f *File, call *Call, name *Name) bool {
 			break
 		}
 	}
-	if !any {
+
+	// A two-result call must always be rewritten, even if none of its
+	// arguments need pointer checks, since it is the rewritten function
+	// literal that captures errno.
+	isErrno := false
+	for _, ref := range f.Ref {
+		if ref.Expr == &call.Call.Fun && ref.Context == "call2" {
+			isErrno = true
+			break
+		}
+	}
+	if !any && !isErrno {
 		return false
 	}
 
@@ -829,7 +952,52 @@ func (p *Package) rewriteCall(f *File, call *Call, name *Name) bool {
 	}
 
 	var fbody ast.Stmt
-	if ftype.Results == nil {
+	if isErrno {
+		// Zero errno before the call, then turn whatever it is
+		// afterwards into the second result.
+		stmts = append([]ast.Stmt{
+			&ast.ExprStmt{X: &ast.CallExpr{Fun: ast.NewIdent("_cgo_errno_reset")}},
+		}, stmts...)
+
+		rname := ast.NewIdent("_cgo_r")
+		var rstmt ast.Stmt
+		if name.FuncType.Result != nil {
+			rstmt = &ast.AssignStmt{
+				Lhs: []ast.Expr{rname},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{fcall},
+			}
+		} else {
+			// The underlying C function returns void; there is no
+			// result to capture, so call it for effect and declare
+			// a zero value of the (synthetic) void result type.
+			stmts = append(stmts, &ast.ExprStmt{X: fcall})
+			rstmt = &ast.DeclStmt{
+				Decl: &ast.GenDecl{
+					Tok: token.VAR,
+					Specs: []ast.Spec{
+						&ast.ValueSpec{
+							Names: []*ast.Ident{rname},
+							Type:  ftype.Results.List[0].Type,
+						},
+					},
+				},
+			}
+		}
+		ename := ast.NewIdent("_cgo_e")
+		estmt := &ast.AssignStmt{
+			Lhs: []ast.Expr{ename},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun:  ast.NewIdent("errnoToError"),
+					Args: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent("_cgo_errno")}},
+				},
+			},
+		}
+		stmts = append(stmts, rstmt, estmt)
+		fbody = &ast.ReturnStmt{Results: []ast.Expr{rname, ename}}
+	} else if ftype.Results == nil {
 		fbody = &ast.ExprStmt{
 			X: fcall,
 		}
@@ -928,7 +1096,11 @@ func (p *Package) hasPointer(f *File, t ast.Expr, top bool) bool {
 			return false
 		}
 		// We can't figure out the type. Conservative
-		// approach is to assume it has a pointer.
+		// approach is to assume it has a pointer. This also
+		// covers the incomplete sentinel substituted for
+		// opaque structs/unions/enums: treating it as a
+		// pointer keeps cgocheck instrumenting pointers to
+		// incomplete types instead of skipping them.
 		return true
 	case *ast.SelectorExpr:
 		if l, ok := t.X.(*ast.Ident); !ok || l.Name != "C" {
@@ -1262,16 +1434,46 @@ func (p *Package) rewriteRef(f *File) {
 // defaultCC is defined in zdefaultcc.go, written by cmd/dist.
 func (p *Package) gccBaseCmd() []string {
 	// Use $CC if set, since that's what the build uses.
-	if ret := strings.Fields(os.Getenv("CC")); len(ret) > 0 {
+	if ret := splitGccEnv("$CC", os.Getenv("CC")); len(ret) > 0 {
 		return ret
 	}
 	// Try $GCC if set, since that's what we used to use.
-	if ret := strings.Fields(os.Getenv("GCC")); len(ret) > 0 {
+	if ret := splitGccEnv("$GCC", os.Getenv("GCC")); len(ret) > 0 {
 		return ret
 	}
-	return strings.Fields(defaultCC)
+	return splitGccEnv("defaultCC", defaultCC)
 }
 
+// splitGccEnv splits a compiler command line taken from the named
+// source (an environment variable, or "defaultCC"), the same way
+// splitQuoted parses a "#cgo CFLAGS:" pragma line: respecting single
+// and double quotes so that values like
+//
+//	CC='ccache clang --sysroot="/opt/my sdk"'
+//
+// are split the way a shell would rather than mangled by naive
+// whitespace splitting. A malformed value (e.g. an unterminated quote)
+// is a hard error: producing a silently-broken argv would only
+// surface later as a confusing gcc invocation failure.
+func splitGccEnv(source, s string) []string {
+	args, err := splitQuoted(s)
+	if err != nil {
+		fatalf("parsing %s: %v", source, err)
+	}
+	return args
+}
+
+// goarm, gomips, gomips64, and goppc64 mirror goarch/goos: the
+// GOARM/GOMIPS/GOMIPS64/GOPPC64 settings for the target being built,
+// consulted by gccMachine to refine the -m flags it emits for
+// architectures with more than one ABI variant.
+var (
+	goarm    = os.Getenv("GOARM")
+	gomips   = os.Getenv("GOMIPS")
+	gomips64 = os.Getenv("GOMIPS64")
+	goppc64  = os.Getenv("GOPPC64")
+)
+
 // gccMachine returns the gcc -m flag to use, either "-m32", "-m64" or "-marm".
 func (p *Package) gccMachine() []string {
 	switch goarch {
@@ -1280,15 +1482,67 @@ func (p *Package) gccMachine() []string {
 	case "386":
 		return []string{"-m32"}
 	case "arm":
-		return []string{"-marm"} // not thumb
+		m := []string{"-marm"} // not thumb
+		switch goarm {
+		case "5":
+			m = append(m, "-march=armv5t", "-mfloat-abi=softfp")
+		case "6":
+			m = append(m, "-march=armv6", "-mfloat-abi=softfp")
+		case "7":
+			m = append(m, "-march=armv7-a")
+		}
+		return m
+	case "arm64":
+		return []string{"-march=armv8-a"}
 	case "s390":
 		return []string{"-m31"}
 	case "s390x":
 		return []string{"-m64"}
 	case "mips64", "mips64le":
-		return []string{"-mabi=64"}
+		m := []string{"-mabi=64"}
+		switch gomips64 {
+		case "hardfloat":
+			m = append(m, "-mhard-float")
+		case "softfloat":
+			m = append(m, "-msoft-float")
+		}
+		return m
 	case "mips", "mipsle":
-		return []string{"-mabi=32"}
+		m := []string{"-mabi=32"}
+		switch gomips {
+		case "hardfloat":
+			m = append(m, "-mhard-float", "-mfp32")
+		case "softfloat":
+			m = append(m, "-msoft-float")
+		}
+		return m
+	case "ppc64":
+		if goos == "aix" {
+			return []string{"-maix64"}
+		}
+		return append([]string{"-m64", "-mbig-endian"}, gccMachinePPC64()...)
+	case "ppc64le":
+		return append([]string{"-m64", "-mlittle-endian"}, gccMachinePPC64()...)
+	case "riscv64":
+		return []string{"-march=rv64gc", "-mabi=lp64d"}
+	case "wasm":
+		// wasm is compiled with a WebAssembly-specific toolchain,
+		// not gcc, so there is no -m flag to add; cgo itself isn't
+		// supported for GOARCH=wasm, but keep gccMachine total over
+		// goarch so callers don't need a special case.
+		return nil
+	}
+	return nil
+}
+
+// gccMachinePPC64 returns the extra gcc flags GOPPC64 selects for
+// ppc64/ppc64le, refining the minimum ISA level gcc should target.
+func gccMachinePPC64() []string {
+	switch goppc64 {
+	case "power9":
+		return []string{"-mcpu=power9"}
+	case "power10":
+		return []string{"-mcpu=power10"}
 	}
 	return nil
 }
@@ -1300,13 +1554,20 @@ func gccTmp() string {
 // gccCmd returns the gcc command line to use for compiling
 // the input.
 func (p *Package) gccCmd() []string {
+	return p.gccCmdAt(gccTmp())
+}
+
+// gccCmdAt is gccCmd, parameterized on the object file path, so that
+// gccparallel.go can run several probes against distinct temporary
+// object files at once.
+func (p *Package) gccCmdAt(objPath string) []string {
 	c := append(p.gccBaseCmd(),
-		"-w",          // no warnings
-		"-Wno-error",  // warnings are not errors
-		"-o"+gccTmp(), // write object to tmp
-		"-gdwarf-2",   // generate DWARF v2 debugging symbols
-		"-c",          // do not link
-		"-xc",         // input language is C
+		"-w",         // no warnings
+		"-Wno-error", // warnings are not errors
+		"-o"+objPath, // write object to tmp
+		"-gdwarf-2",  // generate DWARF v2 debugging symbols
+		"-c",         // do not link
+		"-xc",        // input language is C
 	)
 	if p.GccIsClang {
 		c = append(c,
@@ -1338,17 +1599,49 @@ func (p *Package) gccCmd() []string {
 // returns the corresponding DWARF data and, if present, debug data block.
 func (p *Package) gccDebug(stdin []byte, nnames int) (d *dwarf.Data, ints []int64, floats []float64, strs []string) {
 	runGcc(stdin, p.gccCmd())
+	return p.parseGccObject(nnames)
+}
+
+// gccDebugAt is gccDebug, parameterized on the object file path, so
+// that gccparallel.go can run several DWARF probes concurrently
+// without them clobbering each other's object file.
+func (p *Package) gccDebugAt(stdin []byte, nnames int, objPath string) (d *dwarf.Data, ints []int64, floats []float64, strs []string) {
+	runGcc(stdin, p.gccCmdAt(objPath))
+	return p.parseGccObjectAt(nnames, objPath)
+}
 
+// parseGccObject reads the object file gcc most recently wrote to
+// gccTmp() and extracts its DWARF data and __cgodebug_* values. It is
+// split out of gccDebug so that a cached copy of that object file
+// (see cgocache.go) can be parsed without rerunning gcc.
+func (p *Package) parseGccObject(nnames int) (d *dwarf.Data, ints []int64, floats []float64, strs []string) {
+	return p.parseGccObjectAt(nnames, gccTmp())
+}
+
+// parseGccObjectAt is parseGccObject, parameterized on the object file
+// path, so that the concurrent DWARF probing in gccparallel.go can run
+// several probes against distinct temporary object files at once
+// instead of all contending for the single shared gccTmp() path.
+func (p *Package) parseGccObjectAt(nnames int, objPath string) (d *dwarf.Data, ints []int64, floats []float64, strs []string) {
+	// trimSymDot strips the leading "." that XCOFF puts on AIX's
+	// external symbols, ahead of the usual leading-underscore
+	// decoration handled below.
+	trimSymDot := func(s string) string {
+		return strings.TrimPrefix(s, ".")
+	}
 	isDebugInts := func(s string) bool {
 		// Some systems use leading _ to denote non-assembly symbols.
+		s = trimSymDot(s)
 		return s == "__cgodebug_ints" || s == "___cgodebug_ints"
 	}
 	isDebugFloats := func(s string) bool {
 		// Some systems use leading _ to denote non-assembly symbols.
+		s = trimSymDot(s)
 		return s == "__cgodebug_floats" || s == "___cgodebug_floats"
 	}
 	indexOfDebugStr := func(s string) int {
 		// Some systems use leading _ to denote non-assembly symbols.
+		s = trimSymDot(s)
 		if strings.HasPrefix(s, "___") {
 			s = s[1:]
 		}
@@ -1361,6 +1654,7 @@ func (p *Package) gccDebug(stdin []byte, nnames int) (d *dwarf.Data, ints []int6
 	}
 	indexOfDebugStrlen := func(s string) int {
 		// Some systems use leading _ to denote non-assembly symbols.
+		s = trimSymDot(s)
 		if strings.HasPrefix(s, "___") {
 			s = s[1:]
 		}
@@ -1387,11 +1681,11 @@ func (p *Package) gccDebug(stdin []byte, nnames int) (d *dwarf.Data, ints []int6
 		}
 	}
 
-	if f, err := macho.Open(gccTmp()); err == nil {
+	if f, err := macho.Open(objPath); err == nil {
 		defer f.Close()
 		d, err := f.DWARF()
 		if err != nil {
-			fatalf("cannot load DWARF output from %s: %v", gccTmp(), err)
+			fatalf("cannot load DWARF output from %s: %v", objPath, err)
 		}
 		bo := f.ByteOrder
 		if f.Symtab != nil {
@@ -1465,11 +1759,11 @@ func (p *Package) gccDebug(stdin []byte, nnames int) (d *dwarf.Data, ints []int6
 		return d, ints, floats, strs
 	}
 
-	if f, err := elf.Open(gccTmp()); err == nil {
+	if f, err := elf.Open(objPath); err == nil {
 		defer f.Close()
 		d, err := f.DWARF()
 		if err != nil {
-			fatalf("cannot load DWARF output from %s: %v", gccTmp(), err)
+			fatalf("cannot load DWARF output from %s: %v", objPath, err)
 		}
 		bo := f.ByteOrder
 		symtab, err := f.Symbols()
@@ -1544,11 +1838,11 @@ func (p *Package) gccDebug(stdin []byte, nnames int) (d *dwarf.Data, ints []int6
 		return d, ints, floats, strs
 	}
 
-	if f, err := pe.Open(gccTmp()); err == nil {
+	if f, err := pe.Open(objPath); err == nil {
 		defer f.Close()
 		d, err := f.DWARF()
 		if err != nil {
-			fatalf("cannot load DWARF output from %s: %v", gccTmp(), err)
+			fatalf("cannot load DWARF output from %s: %v", objPath, err)
 		}
 		bo := binary.LittleEndian
 		for _, s := range f.Symbols {
@@ -1616,7 +1910,78 @@ func (p *Package) gccDebug(stdin []byte, nnames int) (d *dwarf.Data, ints []int6
 		return d, ints, floats, strs
 	}
 
-	fatalf("cannot parse gcc output %s as ELF, Mach-O, PE object", gccTmp())
+	if f, err := xcoff.Open(objPath); err == nil {
+		defer f.Close()
+		d, err := f.DWARF()
+		if err != nil {
+			fatalf("cannot load DWARF output from %s: %v", objPath, err)
+		}
+		bo := binary.BigEndian
+		for _, s := range f.Symbols {
+			switch {
+			case isDebugInts(s.Name):
+				if i := int(s.SectionNumber) - 1; 0 <= i && i < len(f.Sections) {
+					sect := f.Sections[i]
+					if s.Value < sect.Size {
+						if sdat, err := sect.Data(); err == nil {
+							data := sdat[s.Value:]
+							ints = make([]int64, len(data)/8)
+							for i := range ints {
+								ints[i] = int64(bo.Uint64(data[i*8:]))
+							}
+						}
+					}
+				}
+			case isDebugFloats(s.Name):
+				if i := int(s.SectionNumber) - 1; 0 <= i && i < len(f.Sections) {
+					sect := f.Sections[i]
+					if s.Value < sect.Size {
+						if sdat, err := sect.Data(); err == nil {
+							data := sdat[s.Value:]
+							floats = make([]float64, len(data)/8)
+							for i := range floats {
+								floats[i] = math.Float64frombits(bo.Uint64(data[i*8:]))
+							}
+						}
+					}
+				}
+			default:
+				if n := indexOfDebugStr(s.Name); n != -1 {
+					if i := int(s.SectionNumber) - 1; 0 <= i && i < len(f.Sections) {
+						sect := f.Sections[i]
+						if s.Value < sect.Size {
+							if sdat, err := sect.Data(); err == nil {
+								data := sdat[s.Value:]
+								strdata[n] = string(data)
+							}
+						}
+					}
+					break
+				}
+				if n := indexOfDebugStrlen(s.Name); n != -1 {
+					if i := int(s.SectionNumber) - 1; 0 <= i && i < len(f.Sections) {
+						sect := f.Sections[i]
+						if s.Value < sect.Size {
+							if sdat, err := sect.Data(); err == nil {
+								data := sdat[s.Value:]
+								strlen := bo.Uint64(data[:8])
+								if strlen > (1<<(uint(p.IntSize*8)-1) - 1) { // greater than MaxInt?
+									fatalf("string literal too big")
+								}
+								strlens[n] = int(strlen)
+							}
+						}
+					}
+					break
+				}
+			}
+		}
+
+		buildStrings()
+		return d, ints, floats, strs
+	}
+
+	fatalf("cannot parse gcc output %s as ELF, Mach-O, PE, or XCOFF object", objPath)
 	panic("not reached")
 }
 
@@ -1857,6 +2222,25 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 	t.C = &TypeRepr{Repr: dtype.Common().Name}
 	c.m[dtype] = t
 
+	// A user-supplied -typemap/"#cgo typemap:" override takes
+	// precedence over the default DWARF-driven synthesis below, so
+	// that e.g. "struct timespec" can be mapped onto syscall.Timespec
+	// instead of cgo inventing its own layout-compatible struct.
+	if e, ok := lookupTypeMap(dtype); ok {
+		t.Go = c.Ident(e.Go)
+		t.C.Set(e.C)
+		if t.Align < 0 {
+			// Natural alignment: same as every other multi-byte type
+			// in this function, capped at the pointer size rather
+			// than set to the full size, which would over-align any
+			// multi-field mapped struct larger than a pointer.
+			if t.Align = t.Size; t.Align >= c.ptrSize {
+				t.Align = c.ptrSize
+			}
+		}
+		return t
+	}
+
 	switch dt := dtype.(type) {
 	default:
 		fatalf("%s: unexpected type: %s", lineno(pos), dtype)
@@ -1935,6 +2319,8 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 			t.Go = c.int64
 		}
 
+		registerEnumConstants(dt, t)
+
 	case *dwarf.FloatType:
 		switch t.Size {
 		default:
@@ -1962,8 +2348,17 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 		}
 
 	case *dwarf.FuncType:
-		// No attempt at translation: would enable calls
+		// Normally no attempt at translation: would enable calls
 		// directly between worlds, but we need to moderate those.
+		// Under -cgo-func-pointers, still register the signature (see
+		// funcptr.go) so a future trampoline-emitting output stage has
+		// the data it needs, but t.Go stays uintptr either way: without
+		// that stage actually generating and linking the trampolines,
+		// handing out a real Go func type would let callers invoke it
+		// directly and call uninstantiated C code.
+		if *funcPointersFlag {
+			registerFuncPointerType(c.FuncType(dt, pos))
+		}
 		t.Go = c.uintptr
 		t.Align = c.ptrSize
 
@@ -2036,6 +2431,39 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 		t.C.Set("%s "+dt.Qual, t1.C)
 		return t
 
+	case *dwarf.UnsupportedType:
+		if dt.Tag != dwarf.TagAtomicType {
+			fatalf("%s: unexpected type: %s", lineno(pos), dtype)
+		}
+		// C11 _Atomic qualifies an operand type that debug/dwarf
+		// doesn't preserve for DW_TAG_atomic_type (see atomictype.go),
+		// so the best we can do is a same-sized stand-in instead of
+		// hard-failing the whole translation. DW_AT_byte_size is
+		// frequently absent for this tag (the qualifier normally
+		// defers to the operand type it wraps, which we don't have),
+		// in which case debug/dwarf reports ByteSize as -1; fall back
+		// to the pointer size, the same width C11 _Atomic's most common
+		// use - an atomic pointer or word-sized integer - actually is.
+		//
+		// t.Go is registerAtomicWrapper's own backing expression
+		// (atomic.Uint32/atomic.Uint64, or a byte array for other
+		// widths), not a made-up _Ctype_atomic_N name: nothing in
+		// this trimmed driver ever declares such a type, so using one
+		// here would translate a C.xxx reference to an identifier
+		// that doesn't exist in the generated package.
+		byteSize := dt.ByteSize
+		if byteSize <= 0 {
+			byteSize = c.ptrSize
+		}
+		label := fmt.Sprintf("_cgo_atomic_%d", len(atomicWrappers))
+		t.Go = registerAtomicWrapper(label, byteSize)
+		t.Size = byteSize
+		t.Align = byteSize
+		if t.Align > c.ptrSize {
+			t.Align = c.ptrSize
+		}
+		t.C.Set("__typeof__(unsigned char[%d])", byteSize)
+
 	case *dwarf.StructType:
 		// Convert to Go struct, being careful about alignment.
 		// Have to give it a name to simulate C "struct foo" references.
@@ -2058,7 +2486,7 @@ func (c *typeConv) Type(dtype dwarf.Type, pos token.Pos) *Type {
 			// other than try to determine a Go representation.
 			tt := *t
 			tt.C = &TypeRepr{"%s %s", []interface{}{dt.Kind, tag}}
-			tt.Go = c.Ident("struct{}")
+			tt.Go = c.Ident(incomplete)
 			typedef[name.Name] = &tt
 			break
 		}
@@ -2351,15 +2779,43 @@ func (c *typeConv) pad(fld []*ast.Field, sizes []int64, size int64) ([]*ast.Fiel
 	return fld, sizes
 }
 
+// flattenAnonFields returns fields with every C11 anonymous struct
+// member (empty Name, Kind "struct") replaced by its own fields,
+// promoted into the caller's field list with their ByteOffset
+// rebased to be relative to the outer struct. It recurses, so an
+// anonymous struct nested inside another anonymous struct is promoted
+// all the way up to the outermost struct, matching how C code
+// addresses such members directly by their inner name. Anonymous
+// unions are left alone here; *godefs* mode already gives them
+// special handling in the caller.
+func flattenAnonFields(fields []*dwarf.StructField) []*dwarf.StructField {
+	var out []*dwarf.StructField
+	for _, f := range fields {
+		st, ok := f.Type.(*dwarf.StructType)
+		if !ok || f.Name != "" || st.Kind != "struct" {
+			out = append(out, f)
+			continue
+		}
+		for _, inner := range flattenAnonFields(st.Field) {
+			promoted := *inner
+			promoted.ByteOffset += f.ByteOffset
+			out = append(out, &promoted)
+		}
+	}
+	return out
+}
+
 // Struct conversion: return Go and (gc) C syntax for type.
 func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.StructType, csyntax string, align int64) {
 	// Minimum alignment for a struct is 1 byte.
 	align = 1
 
+	fields := flattenAnonFields(dt.Field)
+
 	var buf bytes.Buffer
 	buf.WriteString("struct {")
-	fld := make([]*ast.Field, 0, 2*len(dt.Field)+1) // enough for padding around every field
-	sizes := make([]int64, 0, 2*len(dt.Field)+1)
+	fld := make([]*ast.Field, 0, 2*len(fields)+1) // enough for padding around every field
+	sizes := make([]int64, 0, 2*len(fields)+1)
 	off := int64(0)
 
 	// Rename struct fields that happen to be named Go keywords into
@@ -2370,7 +2826,7 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 	// rendered as '__type' in Go).
 	ident := make(map[string]string)
 	used := make(map[string]bool)
-	for _, f := range dt.Field {
+	for _, f := range fields {
 		ident[f.Name] = f.Name
 		used[f.Name] = true
 	}
@@ -2392,13 +2848,63 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 		}
 	}
 
+	// structGo is the Go type name bit-field accessor methods get
+	// registered against; anonymous structs have none, so their
+	// bit-fields still get a backing field but no Getter/Setter.
+	structGo := ""
+	if dt.StructName != "" {
+		structGo = "_Ctype_" + dt.Kind + "_" + dt.StructName
+	}
+
 	anon := 0
-	for _, f := range dt.Field {
+	bitfield := 0
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
 		if f.ByteOffset > off {
 			fld, sizes = c.pad(fld, sizes, f.ByteOffset-off)
 			off = f.ByteOffset
 		}
 
+		if f.BitSize > 0 {
+			// Keep a whole run of bit-fields packed into the same
+			// storage unit as one opaque backing integer field,
+			// rather than dropping them: Go code can still reach
+			// each one through the Getter/Setter pair registered
+			// below for the (absent from this tree) output stage
+			// to emit.
+			run, backingBytes := bitFieldGroup(fields, i)
+			groupOffset := f.ByteOffset
+			backingType := bitFieldBackingType(backingBytes)
+			backingName := fmt.Sprintf("_bitfield%d", bitfield)
+			bitfield++
+
+			n := len(fld)
+			fld = fld[0 : n+1]
+			fld[n] = &ast.Field{Names: []*ast.Ident{c.Ident(backingName)}, Type: backingType}
+			sizes = sizes[0 : n+1]
+			sizes[n] = backingBytes
+			buf.WriteString(bitFieldBackingCType(backingBytes))
+			buf.WriteString(" ")
+			buf.WriteString(backingName)
+			buf.WriteString("; ")
+			off += backingBytes
+			if backingBytes > align {
+				align = backingBytes
+			}
+
+			for _, rf := range run {
+				c.Type(rf.Type, pos) // visit for side effects, same as any other field
+				if rf.Name == "" || structGo == "" {
+					continue
+				}
+				shift := bitFieldLSBOffset(rf, groupOffset, backingBytes)
+				registerBitFieldAccessor(structGo, rf.Name, backingName, rf.BitSize, shift, bitFieldUnsigned(rf.Type))
+			}
+
+			i += len(run) - 1
+			continue
+		}
+
 		name := f.Name
 		ft := f.Type
 
@@ -2415,27 +2921,15 @@ func (c *typeConv) Struct(dt *dwarf.StructType, pos token.Pos) (expr *ast.Struct
 			}
 		}
 
-		// TODO: Handle fields that are anonymous structs by
-		// promoting the fields of the inner struct.
+		// Anonymous struct fields were already promoted into fields
+		// by flattenAnonFields above, with offsets rebased to this
+		// struct, so the only fields still unnamed here are anonymous
+		// unions not renamed just above; they get an anonN name below.
 
 		t := c.Type(ft, pos)
 		tgo := t.Go
 		size := t.Size
 		talign := t.Align
-		if f.BitSize > 0 {
-			if f.BitSize%8 != 0 {
-				continue
-			}
-			size = f.BitSize / 8
-			name := tgo.(*ast.Ident).String()
-			if strings.HasPrefix(name, "int") {
-				name = "int"
-			} else {
-				name = "uint"
-			}
-			tgo = ast.NewIdent(name + fmt.Sprint(f.BitSize))
-			talign = size
-		}
 
 		if talign > 0 && f.ByteOffset%talign != 0 {
 			// Drop misaligned fields, the same way we drop integer bit fields.
@@ -2571,8 +3065,15 @@ func godefsFields(fld []*ast.Field) {
 // people are used to seeing in C.  For generated Go code, such as
 // package syscall's data structures, we drop a common prefix
 // (so sec, usec, which will get turned into Sec, Usec for exporting).
+//
+// This is the same prefix symtab.Sections groups qualifying field
+// names under at the top level, so computing it is a matter of
+// collecting the names Sections needs to see (sorted, with the
+// orig_/_-prefixed and separator-less ones filtered out) and reading
+// off the root section's Prefix - as long as there's exactly one: two
+// root sections mean the fields don't actually agree on a prefix.
 func fieldPrefix(fld []*ast.Field) string {
-	prefix := ""
+	var names []string
 	for _, f := range fld {
 		for _, n := range f.Names {
 			// Ignore field names that don't have the prefix we're
@@ -2583,19 +3084,22 @@ func fieldPrefix(fld []*ast.Field) string {
 			// The check for "orig_" here handles orig_eax in the
 			// x86 ptrace register sets, which otherwise have all fields
 			// with reg_ prefixes.
-			if strings.HasPrefix(n.Name, "orig_") || strings.HasPrefix(n.Name, "_") {
+			if mangle.IsSymbolPrefixOrEqual("orig", n.Name) || strings.HasPrefix(n.Name, "_") {
 				continue
 			}
-			i := strings.Index(n.Name, "_")
-			if i < 0 {
+			if !mangle.HasSeparator(n.Name) {
 				continue
 			}
-			if prefix == "" {
-				prefix = n.Name[:i+1]
-			} else if prefix != n.Name[:i+1] {
-				return ""
-			}
+			names = append(names, n.Name)
 		}
 	}
-	return prefix
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+	secs := symtab.Sections(names)
+	if len(secs) != 1 {
+		return ""
+	}
+	return secs[0].Prefix
 }
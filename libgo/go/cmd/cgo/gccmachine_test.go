@@ -0,0 +1,46 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGccMachine(t *testing.T) {
+	tests := []struct {
+		goarch, goos, goarm, gomips, gomips64, goppc64 string
+		want                                           []string
+	}{
+		{goarch: "arm64", want: []string{"-march=armv8-a"}},
+		{goarch: "riscv64", want: []string{"-march=rv64gc", "-mabi=lp64d"}},
+		{goarch: "wasm", want: nil},
+		{goarch: "arm", goarm: "5", want: []string{"-marm", "-march=armv5t", "-mfloat-abi=softfp"}},
+		{goarch: "arm", goarm: "7", want: []string{"-marm", "-march=armv7-a"}},
+		{goarch: "ppc64", goos: "linux", want: []string{"-m64", "-mbig-endian"}},
+		{goarch: "ppc64", goos: "linux", goppc64: "power9", want: []string{"-m64", "-mbig-endian", "-mcpu=power9"}},
+		{goarch: "ppc64le", want: []string{"-m64", "-mlittle-endian"}},
+		{goarch: "mips", gomips: "softfloat", want: []string{"-mabi=32", "-msoft-float"}},
+		{goarch: "mips64", gomips64: "hardfloat", want: []string{"-mabi=64", "-mhard-float"}},
+	}
+
+	oldArch, oldOS := goarch, goos
+	oldArm, oldMips, oldMips64, oldPPC64 := goarm, gomips, gomips64, goppc64
+	defer func() {
+		goarch, goos = oldArch, oldOS
+		goarm, gomips, gomips64, goppc64 = oldArm, oldMips, oldMips64, oldPPC64
+	}()
+
+	for _, tt := range tests {
+		goarch, goos = tt.goarch, tt.goos
+		goarm, gomips, gomips64, goppc64 = tt.goarm, tt.gomips, tt.gomips64, tt.goppc64
+
+		got := new(Package).gccMachine()
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("gccMachine() for GOARCH=%s GOARM=%s GOMIPS=%s GOMIPS64=%s GOPPC64=%s = %v, want %v",
+				tt.goarch, tt.goarm, tt.gomips, tt.gomips64, tt.goppc64, got, tt.want)
+		}
+	}
+}
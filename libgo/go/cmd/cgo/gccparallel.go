@@ -0,0 +1,258 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/dwarf"
+	"encoding/gob"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// parallelProbeMode controls whether loadDWARF may split a large
+// needType list into independent gcc probes and run them concurrently.
+// It is a separate knob from -cgo-cache (cgocache.go), which memoizes
+// the combined result of a whole probe rather than the individual gcc
+// invocations that produce it.
+var parallelProbeMode = flag.Bool("cgo-probe-parallel", true, "probe DWARF types for many C.xxx names with a worker pool instead of one gcc invocation")
+
+// minNamesForParallelProbe is the smallest needType batch worth
+// splitting: below this, the overhead of extra gcc processes and
+// temporary object files outweighs any parallelism gained.
+const minNamesForParallelProbe = 8
+
+// gccProbeWorkers returns how many concurrent gcc probes loadDWARF
+// should run at once.
+func gccProbeWorkers() int {
+	if n := runtime.NumCPU(); n > 1 {
+		return n
+	}
+	return 1
+}
+
+// loadDWARFConcurrently splits names into up to gccProbeWorkers()
+// batches and resolves each through its own gcc invocation and
+// temporary object file, via a bounded worker pool, instead of a
+// single gcc call covering every name. It reports whether it handled
+// the probe; on false the caller should fall back to the sequential
+// single-batch path.
+func (p *Package) loadDWARFConcurrently(f *File, names []*Name) bool {
+	if !*parallelProbeMode || len(names) < minNamesForParallelProbe {
+		return false
+	}
+
+	workers := gccProbeWorkers()
+	batches := splitNames(names, workers)
+	if len(batches) <= 1 {
+		return false
+	}
+
+	// Only the gcc invocation and DWARF parsing run concurrently;
+	// each produces results private to its own batch. Populating
+	// f.Name from those results is left to the caller's goroutine,
+	// one batch at a time, because typeConv.Type mutates the
+	// process-wide typedef/goIdent/unionWithPointer maps and isn't
+	// safe to call from multiple goroutines at once.
+	results := make([]dwarfProbeResult, len(batches))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	for i, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, batch []*Name) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = p.probeDWARFBatch(f, batch, i)
+		}(i, batch)
+	}
+	wg.Wait()
+
+	for i, batch := range batches {
+		r := results[i]
+		p.populateFromDWARF(f, batch, r.d, r.ints, r.floats, r.strs)
+	}
+	return true
+}
+
+// dwarfProbeResult is one batch's raw probe output, gathered
+// concurrently in loadDWARFConcurrently and applied to f.Name
+// sequentially afterward.
+type dwarfProbeResult struct {
+	d      *dwarf.Data
+	ints   []int64
+	floats []float64
+	strs   []string
+}
+
+// splitNames divides names into at most n roughly-equal, contiguous
+// batches, preserving relative order within each batch.
+func splitNames(names []*Name, n int) [][]*Name {
+	if len(names) == 0 {
+		return nil
+	}
+	if n > len(names) {
+		n = len(names)
+	}
+	size := (len(names) + n - 1) / n
+	var batches [][]*Name
+	for i := 0; i < len(names); i += size {
+		end := i + size
+		if end > len(names) {
+			end = len(names)
+		}
+		batches = append(batches, names[i:end])
+	}
+	return batches
+}
+
+// probeDWARFBatch builds the same synthetic __cgo__i probe program
+// loadDWARF does, but for a single batch, compiles it to a temporary
+// object file unique to batch index i (so concurrent batches never
+// contend for gccTmp()), and returns the raw DWARF/debug-data result
+// for the caller to apply once back on its own goroutine.
+func (p *Package) probeDWARFBatch(f *File, names []*Name, batch int) dwarfProbeResult {
+	b := p.dwarfProbeSource(f, names)
+
+	objPath := fmt.Sprintf("%s.probe%d", gccTmp(), batch)
+	defer os.Remove(objPath)
+
+	key := gccProbeCacheKey(p.gccCmdAt(objPath), b)
+	if e, ok := gccProbeCacheLoad(key); ok {
+		if err := ioutil.WriteFile(objPath, e.Obj, 0644); err == nil {
+			d, ints, floats, strs := p.parseGccObjectAt(len(names), objPath)
+			return dwarfProbeResult{d, ints, floats, strs}
+		}
+	}
+
+	d, ints, floats, strs := p.gccDebugAt(b, len(names), objPath)
+
+	if obj, err := ioutil.ReadFile(objPath); err == nil {
+		gccProbeCacheStore(key, &gccProbeCacheEntry{Obj: obj})
+	}
+
+	return dwarfProbeResult{d, ints, floats, strs}
+}
+
+// dwarfProbeSource is the same __typeof__/__cgodebug_* source text
+// loadDWARF builds, factored out so probeDWARFBatch can build it per
+// batch and the probe cache can hash it as part of its key.
+func (p *Package) dwarfProbeSource(f *File, names []*Name) []byte {
+	var b bytes.Buffer
+	b.WriteString(f.Preamble)
+	b.WriteString(builtinProlog)
+	b.WriteString("#line 1 \"cgo-dwarf-inference\"\n")
+	for i, n := range names {
+		fmt.Fprintf(&b, "__typeof__(%s) *__cgo__%d;\n", n.C, i)
+		if n.Kind == "iconst" || n.Kind == "uconst" {
+			fmt.Fprintf(&b, "enum { __cgo_enum__%d = %s };\n", i, n.C)
+		}
+	}
+
+	fmt.Fprintf(&b, "long long __cgodebug_ints[] = {\n")
+	for _, n := range names {
+		if n.Kind == "iconst" || n.Kind == "uconst" {
+			fmt.Fprintf(&b, "\t%s,\n", n.C)
+		} else {
+			fmt.Fprintf(&b, "\t0,\n")
+		}
+	}
+	fmt.Fprintf(&b, "\t1\n")
+	fmt.Fprintf(&b, "};\n")
+
+	fmt.Fprintf(&b, "double __cgodebug_floats[] = {\n")
+	for _, n := range names {
+		if n.Kind == "fconst" {
+			fmt.Fprintf(&b, "\t%s,\n", n.C)
+		} else {
+			fmt.Fprintf(&b, "\t0,\n")
+		}
+	}
+	fmt.Fprintf(&b, "\t1\n")
+	fmt.Fprintf(&b, "};\n")
+
+	for i, n := range names {
+		if n.Kind == "sconst" {
+			fmt.Fprintf(&b, "const char __cgodebug_str__%d[] = %s;\n", i, n.C)
+			fmt.Fprintf(&b, "const unsigned long long __cgodebug_strlen__%d = sizeof(%s)-1;\n", i, n.C)
+		}
+	}
+
+	return b.Bytes()
+}
+
+// gccProbeCacheEntry is the on-disk, cross-run cache entry for a
+// single gcc DWARF probe invocation: the compiled object file, keyed
+// by the exact argv and stdin that produced it. It is deliberately
+// lower-level than cgoCacheEntry (cgocache.go), which memoizes the
+// resolved Name results for a whole preamble; this one lets two
+// probes that happen to build byte-identical gcc input -- whether
+// across runs or, for the split-batch path above, never needing to
+// rerun gcc for unchanged batches -- skip the gcc invocation, even
+// when the higher-level cache above it misses.
+type gccProbeCacheEntry struct {
+	Obj []byte
+}
+
+// gccProbeCacheKey hashes the gcc argv and stdin together, so any
+// change to either invalidates the cached object file.
+func gccProbeCacheKey(args []string, stdin []byte) string {
+	h := sha256.New()
+	for _, a := range args {
+		io.WriteString(h, a)
+		io.WriteString(h, "\x00")
+	}
+	h.Write(stdin)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func gccProbeCacheDir() string {
+	if dir := os.Getenv("GOCACHE"); dir != "" {
+		return filepath.Join(dir, "cgo-probe")
+	}
+	return filepath.Join(os.TempDir(), "go-cgo-probe-cache")
+}
+
+func gccProbeCacheLoad(key string) (*gccProbeCacheEntry, bool) {
+	if !cgoCacheRead() {
+		return nil, false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(gccProbeCacheDir(), key))
+	if err != nil {
+		return nil, false
+	}
+	var e gccProbeCacheEntry
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&e); err != nil {
+		return nil, false
+	}
+	return &e, true
+}
+
+func gccProbeCacheStore(key string, e *gccProbeCacheEntry) {
+	if !cgoCacheWrite() {
+		return
+	}
+	dir := gccProbeCacheDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(e); err != nil {
+		return
+	}
+	tmp := filepath.Join(dir, key+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return
+	}
+	os.Rename(tmp, filepath.Join(dir, key))
+}
@@ -0,0 +1,235 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package constraint implements parsing and evaluation of the boolean
+// build-tag expressions that appear in QFTASM source fragments and
+// intrinsic stub files, in `//qft:build <expr>` comment lines. It is
+// modeled directly on the standard library's go/build/constraint,
+// which does the same job for `//go:build` lines: <expr> is an
+// expression over tag identifiers (e.g. mul, div, mem16, rev2)
+// combined with &&, ||, !, and parentheses.
+package constraint
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Expr is a build tag boolean expression. The concrete types are
+// *TagExpr, *NotExpr, *AndExpr, and *OrExpr.
+type Expr interface {
+	// Eval reports whether the expression evaluates to true, using ok
+	// to decide whether a given tag is satisfied.
+	Eval(ok func(tag string) bool) bool
+
+	// String returns a round-trippable textual form of the expression.
+	String() string
+}
+
+// A TagExpr is an Expr for a single tag, such as "mul".
+type TagExpr struct {
+	Tag string
+}
+
+func (x *TagExpr) Eval(ok func(string) bool) bool { return ok(x.Tag) }
+func (x *TagExpr) String() string                 { return x.Tag }
+
+// A NotExpr is an Expr for !X.
+type NotExpr struct {
+	X Expr
+}
+
+func (x *NotExpr) Eval(ok func(string) bool) bool { return !x.X.Eval(ok) }
+func (x *NotExpr) String() string                 { return "!" + parenString(x.X) }
+
+// An AndExpr is an Expr for X && Y.
+type AndExpr struct {
+	X, Y Expr
+}
+
+func (x *AndExpr) Eval(ok func(string) bool) bool { return x.X.Eval(ok) && x.Y.Eval(ok) }
+func (x *AndExpr) String() string                 { return fmt.Sprintf("%s && %s", parenString(x.X), parenString(x.Y)) }
+
+// An OrExpr is an Expr for X || Y.
+type OrExpr struct {
+	X, Y Expr
+}
+
+func (x *OrExpr) Eval(ok func(string) bool) bool { return x.X.Eval(ok) || x.Y.Eval(ok) }
+func (x *OrExpr) String() string                 { return fmt.Sprintf("%s || %s", parenString(x.X), parenString(x.Y)) }
+
+// parenString returns x's String, parenthesized if x is an AndExpr or
+// OrExpr, so that precedence survives round-tripping through String.
+func parenString(x Expr) string {
+	switch x.(type) {
+	case *AndExpr, *OrExpr:
+		return "(" + x.String() + ")"
+	}
+	return x.String()
+}
+
+// buildLinePrefix and legacyBuildLinePrefix are the two comment forms
+// a fragment's constraint line may take: the current "//qft:build"
+// form, and the one-release-deprecated "// +qft:build" form carried
+// over from the original ad-hoc tagging convention.
+const (
+	buildLinePrefix       = "//qft:build"
+	legacyBuildLinePrefix = "// +qft:build"
+)
+
+// IsBuildLine reports whether line is a "//qft:build" constraint line.
+func IsBuildLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), buildLinePrefix)
+}
+
+// IsLegacyBuildLine reports whether line is a "// +qft:build" line,
+// the deprecated spelling kept working for one release.
+func IsLegacyBuildLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), legacyBuildLinePrefix)
+}
+
+// Parse parses a single constraint line, in either its current
+// "//qft:build <expr>" form or its deprecated "// +qft:build <expr>"
+// form, and returns the parsed expression. Like go/build/constraint's
+// handling of //go:build versus the old +build syntax, the two forms
+// are tried in turn rather than merged into one grammar: Parse first
+// checks for the current prefix, then falls back to the legacy one,
+// so callers that also want a deprecation warning can distinguish the
+// two with IsLegacyBuildLine before calling Parse.
+func Parse(line string) (Expr, error) {
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, buildLinePrefix):
+		return parseExpr(trimmed[len(buildLinePrefix):])
+	case strings.HasPrefix(trimmed, legacyBuildLinePrefix):
+		return parseExpr(trimmed[len(legacyBuildLinePrefix):])
+	}
+	return nil, fmt.Errorf("constraint: not a build line: %q", line)
+}
+
+// parseExpr parses the boolean expression following a build line's
+// prefix, using && and || (left-associative, && binding tighter than
+// ||), ! for negation, and parentheses for grouping.
+func parseExpr(s string) (Expr, error) {
+	p := &parser{s: s}
+	p.next()
+	if p.tok == "" {
+		return nil, fmt.Errorf("constraint: empty expression")
+	}
+	x, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, fmt.Errorf("constraint: unexpected token %q", p.tok)
+	}
+	return x, nil
+}
+
+type parser struct {
+	s   string
+	tok string
+}
+
+func (p *parser) next() {
+	p.s = strings.TrimSpace(p.s)
+	if p.s == "" {
+		p.tok = ""
+		return
+	}
+	switch p.s[0] {
+	case '(', ')', '!':
+		p.tok = p.s[:1]
+		p.s = p.s[1:]
+		return
+	}
+	if strings.HasPrefix(p.s, "&&") {
+		p.tok = "&&"
+		p.s = p.s[2:]
+		return
+	}
+	if strings.HasPrefix(p.s, "||") {
+		p.tok = "||"
+		p.s = p.s[2:]
+		return
+	}
+	i := 0
+	for i < len(p.s) && isTagChar(p.s[i]) {
+		i++
+	}
+	if i == 0 {
+		p.tok = p.s[:1]
+		p.s = p.s[1:]
+		return
+	}
+	p.tok = p.s[:i]
+	p.s = p.s[i:]
+}
+
+func isTagChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// or parses a '||'-separated sequence of and expressions.
+func (p *parser) or() (Expr, error) {
+	x, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "||" {
+		p.next()
+		y, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		x = &OrExpr{X: x, Y: y}
+	}
+	return x, nil
+}
+
+// and parses a '&&'-separated sequence of unary expressions.
+func (p *parser) and() (Expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok == "&&" {
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		x = &AndExpr{X: x, Y: y}
+	}
+	return x, nil
+}
+
+// unary parses a possibly-negated, possibly-parenthesized tag.
+func (p *parser) unary() (Expr, error) {
+	switch {
+	case p.tok == "!":
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &NotExpr{X: x}, nil
+	case p.tok == "(":
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, fmt.Errorf("constraint: missing close paren")
+		}
+		p.next()
+		return x, nil
+	case p.tok == "" || p.tok == ")" || p.tok == "&&" || p.tok == "||":
+		return nil, fmt.Errorf("constraint: unexpected token %q", p.tok)
+	}
+	tag := p.tok
+	p.next()
+	return &TagExpr{Tag: tag}, nil
+}
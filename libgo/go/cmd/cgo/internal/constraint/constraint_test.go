@@ -0,0 +1,89 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package constraint
+
+import "testing"
+
+func TestParseAndEval(t *testing.T) {
+	tests := []struct {
+		line string
+		tags map[string]bool
+		want bool
+	}{
+		{"//qft:build mul", map[string]bool{"mul": true}, true},
+		{"//qft:build mul", map[string]bool{"mul": false}, false},
+		{"//qft:build mul && div", map[string]bool{"mul": true, "div": true}, true},
+		{"//qft:build mul && div", map[string]bool{"mul": true, "div": false}, false},
+		{"//qft:build mul || div", map[string]bool{"mul": false, "div": true}, true},
+		{"//qft:build !mul", map[string]bool{"mul": false}, true},
+		{"//qft:build !mul", map[string]bool{"mul": true}, false},
+		{"//qft:build (mul || div) && rev2", map[string]bool{"mul": true, "div": false, "rev2": true}, true},
+		{"//qft:build (mul || div) && rev2", map[string]bool{"mul": false, "div": false, "rev2": true}, false},
+		{"//qft:build mul && !div", map[string]bool{"mul": true, "div": true}, false},
+		{"// +qft:build mul && mem16", map[string]bool{"mul": true, "mem16": true}, true},
+	}
+	for _, tt := range tests {
+		x, err := Parse(tt.line)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.line, err)
+		}
+		ok := func(tag string) bool { return tt.tags[tag] }
+		if got := x.Eval(ok); got != tt.want {
+			t.Errorf("Parse(%q).Eval(%v) = %v, want %v", tt.line, tt.tags, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		"not a build line",
+		"//qft:build",
+		"//qft:build mul &&",
+		"//qft:build (mul",
+		"//qft:build mul mul",
+	}
+	for _, line := range tests {
+		if _, err := Parse(line); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", line)
+		}
+	}
+}
+
+func TestIsBuildLine(t *testing.T) {
+	if !IsBuildLine("//qft:build mul") {
+		t.Errorf("IsBuildLine did not recognize a current-form line")
+	}
+	if IsBuildLine("// +qft:build mul") {
+		t.Errorf("IsBuildLine recognized a legacy-form line")
+	}
+	if !IsLegacyBuildLine("// +qft:build mul") {
+		t.Errorf("IsLegacyBuildLine did not recognize a legacy-form line")
+	}
+	if IsLegacyBuildLine("//qft:build mul") {
+		t.Errorf("IsLegacyBuildLine recognized a current-form line")
+	}
+}
+
+func TestString(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"//qft:build mul", "mul"},
+		{"//qft:build mul && div", "mul && div"},
+		{"//qft:build mul || div", "mul || div"},
+		{"//qft:build !mul", "!mul"},
+		{"//qft:build (mul || div) && rev2", "(mul || div) && rev2"},
+	}
+	for _, tt := range tests {
+		x, err := Parse(tt.line)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.line, err)
+		}
+		if got := x.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package mangle holds small utilities for reasoning about cgo's
+// mangled symbol names (the _Ctype_/_Ciconst_/_cgo_-style identifiers
+// cgo generates, and the C/QFTASM symbols they're derived from).
+package mangle
+
+import "strings"
+
+// IsSymbolPrefixOrEqual reports whether prefix and name name the same
+// symbol, or name lives in the namespace prefix denotes: prefix ==
+// name, or name continues past prefix with one of the separators (_,
+// ., $) that mangled names use to join namespace components.
+//
+// This is deliberately stricter than strings.HasPrefix(name, prefix):
+// "foo_bar" and "foobar" both start with the bytes "foo", but only
+// "foo_bar" is actually inside the "foo" namespace. A plain HasPrefix
+// check conflates the two, which silently mis-groups unrelated
+// symbols whenever one name's next token happens to start where
+// another's separator does.
+func IsSymbolPrefixOrEqual(prefix, name string) bool {
+	if name == prefix {
+		return true
+	}
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	switch name[len(prefix)] {
+	case '_', '.', '$':
+		return true
+	default:
+		return false
+	}
+}
+
+// HasSeparator reports whether name contains at least one of the
+// separators (_, ., $) that mangled names use to join namespace
+// components, i.e. whether name could be split into more than one
+// component at all.
+func HasSeparator(name string) bool {
+	return strings.ContainsAny(name, "_.$")
+}
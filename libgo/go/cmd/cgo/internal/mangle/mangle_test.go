@@ -0,0 +1,50 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package mangle
+
+import "testing"
+
+func TestIsSymbolPrefixOrEqual(t *testing.T) {
+	tests := []struct {
+		prefix, name string
+		want         bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foo_bar", true},
+		{"foo", "foo.bar", true},
+		{"foo", "foo$bar", true},
+		{"foo", "foobar", false},
+		{"foo", "foo", true},
+		// The separator belongs to the continuation, not to prefix:
+		// "foo_" already names its own (different) namespace, so it
+		// doesn't itself continue into "foo_bar".
+		{"foo_", "foo_bar", false},
+		{"foo", "fo", false},
+		{"foo", "bar", false},
+	}
+	for _, tt := range tests {
+		if got := IsSymbolPrefixOrEqual(tt.prefix, tt.name); got != tt.want {
+			t.Errorf("IsSymbolPrefixOrEqual(%q, %q) = %v, want %v", tt.prefix, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHasSeparator(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"tv_sec", true},
+		{"foo.bar", true},
+		{"foo$bar", true},
+		{"onlyone", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := HasSeparator(tt.name); got != tt.want {
+			t.Errorf("HasSeparator(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
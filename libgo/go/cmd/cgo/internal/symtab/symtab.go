@@ -0,0 +1,83 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package symtab builds a hierarchical view of a flat list of mangled
+// symbol names, for tools (like cgo's -symbol-map) that want to show
+// "module_file_func_local"-style names as the nested namespace they
+// actually denote rather than as one undifferentiated list.
+package symtab
+
+import "strings"
+
+// Section is one level of the namespace tree Sections builds: every
+// name that shares Prefix at this depth, further split into Subs if
+// Prefix doesn't already name a complete symbol.
+type Section struct {
+	Prefix   string
+	Subs     []*Section
+	NumLines int // number of names under this section, leaf or not
+}
+
+// NextPrefixFunc computes the next level's prefix for name, given the
+// prefix already established one level up (the empty string at the
+// root). It must return a string that has prefix as a Go string
+// prefix; returning prefix itself back unchanged signals that name
+// can't be subdivided any further.
+type NextPrefixFunc func(name, prefix string) string
+
+// DefaultNextPrefix extends prefix up to and including the next "_"
+// in name, or returns name itself if name has no further "_" beyond
+// prefix - e.g. DefaultNextPrefix("tv_sec", "") is "tv_", and
+// DefaultNextPrefix("tv_sec", "tv_") is "tv_sec".
+func DefaultNextPrefix(name, prefix string) string {
+	rest := name[len(prefix):]
+	i := strings.IndexByte(rest, '_')
+	if i < 0 {
+		return name
+	}
+	return name[:len(prefix)+i+1]
+}
+
+// Sections groups names - assumed already sorted - into a tree of
+// Sections using DefaultNextPrefix.
+func Sections(names []string) []*Section {
+	return SectionsFunc(names, DefaultNextPrefix)
+}
+
+// SectionsFunc is Sections with a pluggable nextPrefix, for grouping
+// conventions other than an underscore-delimited hierarchy.
+func SectionsFunc(names []string, nextPrefix NextPrefixFunc) []*Section {
+	return sections(names, "", nextPrefix)
+}
+
+func sections(names []string, prefix string, nextPrefix NextPrefixFunc) []*Section {
+	if len(names) == 0 {
+		return nil
+	}
+
+	groups := make(map[string][]string)
+	var order []string
+	for _, n := range names {
+		np := nextPrefix(n, prefix)
+		if _, ok := groups[np]; !ok {
+			order = append(order, np)
+		}
+		groups[np] = append(groups[np], n)
+	}
+
+	out := make([]*Section, 0, len(order))
+	for _, np := range order {
+		members := groups[np]
+		sec := &Section{Prefix: np, NumLines: len(members)}
+		// Stop subdividing once nextPrefix stops making progress (np
+		// came back equal to prefix) or this group is down to the one
+		// name np already spells out in full; either way there's
+		// nothing left to split members on.
+		if np != prefix && !(len(members) == 1 && np == members[0]) {
+			sec.Subs = sections(members, np, nextPrefix)
+		}
+		out = append(out, sec)
+	}
+	return out
+}
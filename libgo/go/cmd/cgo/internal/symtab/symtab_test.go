@@ -0,0 +1,58 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package symtab
+
+import "testing"
+
+func TestDefaultNextPrefix(t *testing.T) {
+	tests := []struct {
+		name, prefix, want string
+	}{
+		{"tv_sec", "", "tv_"},
+		{"tv_sec", "tv_", "tv_sec"},
+		{"onlyone", "", "onlyone"},
+	}
+	for _, tt := range tests {
+		if got := DefaultNextPrefix(tt.name, tt.prefix); got != tt.want {
+			t.Errorf("DefaultNextPrefix(%q, %q) = %q, want %q", tt.name, tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestSections(t *testing.T) {
+	names := []string{"reg_eax", "tv_sec", "tv_usec"}
+	secs := Sections(names)
+	if len(secs) != 2 {
+		t.Fatalf("Sections(%v) returned %d root sections, want 2", names, len(secs))
+	}
+	if secs[0].Prefix != "reg_" || secs[0].NumLines != 1 {
+		t.Errorf("secs[0] = %+v, want Prefix reg_, NumLines 1", secs[0])
+	}
+	if len(secs[0].Subs) != 1 || secs[0].Subs[0].Prefix != "reg_eax" {
+		t.Errorf("secs[0].Subs = %+v, want a single reg_eax leaf", secs[0].Subs)
+	}
+	if secs[1].Prefix != "tv_" || secs[1].NumLines != 2 {
+		t.Errorf("secs[1] = %+v, want Prefix tv_, NumLines 2", secs[1])
+	}
+	if len(secs[1].Subs) != 2 {
+		t.Fatalf("secs[1].Subs = %+v, want 2 entries", secs[1].Subs)
+	}
+	if secs[1].Subs[0].Prefix != "tv_sec" || secs[1].Subs[1].Prefix != "tv_usec" {
+		t.Errorf("secs[1].Subs = %+v, want tv_sec then tv_usec", secs[1].Subs)
+	}
+}
+
+func TestSectionsSingleton(t *testing.T) {
+	secs := Sections([]string{"onlyone"})
+	if len(secs) != 1 || secs[0].Prefix != "onlyone" || secs[0].Subs != nil {
+		t.Errorf("Sections([onlyone]) = %+v, want a single leaf section", secs)
+	}
+}
+
+func TestSectionsEmpty(t *testing.T) {
+	if secs := Sections(nil); secs != nil {
+		t.Errorf("Sections(nil) = %+v, want nil", secs)
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// errnoProlog is the source text an output-writing stage would prepend
+// to any generated file containing a two-result "call2" reference (see
+// rewriteCall): it defines _cgo_errno, _cgo_errno_reset and
+// errnoToError against the errno snapshot the C-side trampoline
+// emitted for that call takes immediately after invoking the
+// underlying function, before any Go code - and so before any
+// goroutine reschedule that could clobber errno - runs again.
+//
+// None of the three can be real declarations in this package: they
+// read and clear a variable the trampoline sets, and this trimmed
+// driver has no stage that emits that trampoline, the same gap
+// funcPointerTrampolines and bitFieldAccessorsFor document for their
+// own generated glue. A bodied stand-in here would claim a snapshot
+// taken by code that doesn't exist, and rewriteCall's AST only ever
+// references these names by identifier for the generated package to
+// resolve - the tool itself never calls them.
+const errnoProlog = `
+var _cgo_errno_v syscall.Errno
+
+func _cgo_errno() syscall.Errno {
+	return _cgo_errno_v
+}
+
+func _cgo_errno_reset() {
+	_cgo_errno_v = 0
+}
+
+func errnoToError(e syscall.Errno) error {
+	if e == 0 {
+		return nil
+	}
+	return e
+}
+`
@@ -0,0 +1,87 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"cmd/cgo/internal/symtab"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var symbolMapFlag = flag.String("symbol-map", "", "`file` to write a hierarchical, prefix-grouped listing of every mangled symbol to")
+
+// mangledSymbol records one name cgo has mangled, paired with the
+// original C-side (or QFTASM-side) name it came from.
+type mangledSymbol struct {
+	Mangle string
+	Source string
+}
+
+var (
+	symbolMapMu       sync.Mutex
+	mangledSymbols    []mangledSymbol
+	mangledSymbolSeen = make(map[string]bool)
+)
+
+// recordMangledSymbol notes that mangle was produced from source, for
+// later inclusion in the -symbol-map output. Package.mangleName is its
+// only caller; names minted elsewhere (such as typeConv.Type's
+// _Ctype_ names or registerEnumConstants' _Ciconst_ names) are not
+// recorded.
+func recordMangledSymbol(mangle, source string) {
+	symbolMapMu.Lock()
+	defer symbolMapMu.Unlock()
+	if mangledSymbolSeen[mangle] {
+		return
+	}
+	mangledSymbolSeen[mangle] = true
+	mangledSymbols = append(mangledSymbols, mangledSymbol{Mangle: mangle, Source: source})
+}
+
+// writeSymbolMap writes the -symbol-map output, grouping every
+// recorded mangled symbol into a Section tree via package symtab. It
+// is a no-op if -symbol-map wasn't given.
+//
+// As with funcPointerTrampolines and bitFieldAccessorsFor, there is no
+// call to writeSymbolMap yet: this trimmed driver has no output stage
+// to invoke it from, so it is wired up as far as a real driver could
+// reach and left otherwise unused until that stage exists.
+func writeSymbolMap() error {
+	if *symbolMapFlag == "" {
+		return nil
+	}
+
+	symbolMapMu.Lock()
+	bySource := make(map[string]string, len(mangledSymbols))
+	names := make([]string, 0, len(mangledSymbols))
+	for _, s := range mangledSymbols {
+		names = append(names, s.Mangle)
+		bySource[s.Mangle] = s.Source
+	}
+	symbolMapMu.Unlock()
+
+	sort.Strings(names)
+	var buf bytes.Buffer
+	writeSections(&buf, symtab.Sections(names), 0, bySource)
+	return ioutil.WriteFile(*symbolMapFlag, buf.Bytes(), 0644)
+}
+
+func writeSections(buf *bytes.Buffer, secs []*symtab.Section, depth int, bySource map[string]string) {
+	for _, s := range secs {
+		fmt.Fprintf(buf, "%s%s (%d)", strings.Repeat("  ", depth), s.Prefix, s.NumLines)
+		if len(s.Subs) == 0 {
+			if src, ok := bySource[s.Prefix]; ok {
+				fmt.Fprintf(buf, " -- C.%s", src)
+			}
+		}
+		buf.WriteByte('\n')
+		writeSections(buf, s.Subs, depth+1, bySource)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"debug/dwarf"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// typemapFlag names a JSON file of user-supplied C->Go type overrides,
+// in addition to any given by "#cgo typemap:" preamble directives.
+var typemapFlag = flag.String("typemap", "", "`file` of C type to Go type overrides, in JSON")
+
+// typeMapEntry is one override: whenever the C type named C is
+// encountered, use the Go type named Go instead of synthesizing one
+// from its DWARF definition. Go may be package-qualified (e.g.
+// "syscall.Timespec"), in which case lookupTypeMap records that
+// package for neededTypeMapImports to list; nothing in this driver
+// adds it to a generated import block.
+type typeMapEntry struct {
+	C  string `json:"c"`
+	Go string `json:"go"`
+}
+
+var (
+	typeMapOnce sync.Once
+	typeMapByC  = map[string]typeMapEntry{}
+
+	typeMapImportMu   sync.Mutex
+	typeMapImportSeen = map[string]bool{}
+	typeMapImportList []string
+)
+
+// loadTypeMapFile merges the overrides in the JSON file at path into
+// the process-wide type map. It is safe to call more than once, for
+// example once for -typemap and once per "#cgo typemap:" directive.
+func loadTypeMapFile(path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		fatalf("reading -typemap file: %v", err)
+	}
+	var entries []typeMapEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		fatalf("parsing -typemap file %s: %v", path, err)
+	}
+	for _, e := range entries {
+		typeMapByC[e.C] = e
+	}
+}
+
+// initTypeMap loads the -typemap flag's file, if any, exactly once.
+// "#cgo typemap:" directives are merged in separately, from
+// DiscardCgoDirectives, as each file's preamble is processed.
+func initTypeMap() {
+	typeMapOnce.Do(func() {
+		if *typemapFlag != "" {
+			loadTypeMapFile(*typemapFlag)
+		}
+	})
+}
+
+// dwarfTypeCName returns the C spelling typeMapByC is keyed on for
+// dtype, or "" if dtype doesn't have one of the forms a typemap entry
+// can name (a struct, union or class tag name, or a typedef name).
+// Enum tags are not looked up.
+func dwarfTypeCName(dtype dwarf.Type) string {
+	switch dt := dtype.(type) {
+	case *dwarf.TypedefType:
+		return dt.Name
+	case *dwarf.StructType:
+		if dt.StructName == "" {
+			return ""
+		}
+		return strings.TrimSpace(dt.Kind + " " + dt.StructName)
+	}
+	return ""
+}
+
+// lookupTypeMap reports whether dtype has a user-supplied override,
+// returning the override's Go spelling if so. Every override actually
+// used this way is recorded by recordTypeMapImport, so
+// neededTypeMapImports only ever lists packages a generated file
+// genuinely references, not merely every entry present in the map.
+func lookupTypeMap(dtype dwarf.Type) (typeMapEntry, bool) {
+	name := dwarfTypeCName(dtype)
+	if name == "" {
+		return typeMapEntry{}, false
+	}
+	e, ok := typeMapByC[name]
+	if ok {
+		recordTypeMapImport(e.Go)
+	}
+	return e, ok
+}
+
+// recordTypeMapImport notes that goType's package (the part before its
+// last ".", if any) must be imported into the generated output for a
+// typemap override that was actually applied. A goType with no "." -
+// an unqualified, same-package type - needs no import.
+func recordTypeMapImport(goType string) {
+	i := strings.LastIndex(goType, ".")
+	if i < 0 {
+		return
+	}
+	pkg := goType[:i]
+
+	typeMapImportMu.Lock()
+	defer typeMapImportMu.Unlock()
+	if typeMapImportSeen[pkg] {
+		return
+	}
+	typeMapImportSeen[pkg] = true
+	typeMapImportList = append(typeMapImportList, pkg)
+}
+
+// neededTypeMapImports returns the packages recordTypeMapImport has
+// collected so far, for the output-writing stage to add to the
+// generated file's import block (as with funcPointerTrampolines and
+// bitFieldAccessorsFor, that stage doesn't exist in this trimmed
+// driver, so nothing calls this yet).
+func neededTypeMapImports() []string {
+	typeMapImportMu.Lock()
+	defer typeMapImportMu.Unlock()
+	return append([]string(nil), typeMapImportList...)
+}